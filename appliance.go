@@ -0,0 +1,179 @@
+package kasa
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+)
+
+const (
+	DEFAULT_APPLIANCE_INTERVAL = 10 * time.Second
+	DEFAULT_APPLIANCE_STOP_RATIO = 0.5
+)
+
+var ErrNoEmeter = errors.New("device has no energy meter")
+
+type ApplianceState string
+
+const (
+	ApplianceIdle = ApplianceState("idle")
+	ApplianceRunning = ApplianceState("running")
+	ApplianceFinished = ApplianceState("finished")
+)
+
+// ApplianceOptions configures an ApplianceDetector.  An appliance starts
+// running once it draws at least StartWatts for MinRunTime, and finishes
+// once it draws no more than StopWatts for MinStopTime.  Keeping StopWatts
+// below StartWatts stops readings that hover around one threshold from
+// flapping between states, and MinStopTime rides out pauses mid-cycle,
+// like a washer soaking.  A zero StopWatts means
+// DEFAULT_APPLIANCE_STOP_RATIO of StartWatts, since most appliances draw
+// some power on standby.
+type ApplianceOptions struct {
+	StartWatts float64
+	StopWatts float64
+	MinRunTime time.Duration
+	MinStopTime time.Duration
+	// FinishedTimeout is how long the appliance stays finished before
+	// going back to idle.  Zero means it stays finished until it starts
+	// running again.
+	FinishedTimeout time.Duration
+	// Interval is how often Run takes readings,
+	// DEFAULT_APPLIANCE_INTERVAL if zero.
+	Interval time.Duration
+}
+
+// ApplianceEvent reports a change of appliance state.  For
+// ApplianceFinished, Started is when the run began and Duration is how long
+// it ran, not counting the quiet MinStopTime at the end.
+type ApplianceEvent struct {
+	Previous ApplianceState `json:"previous"`
+	State ApplianceState `json:"state"`
+	Time time.Time `json:"time"`
+	Watts float64 `json:"watts"`
+	Started time.Time `json:"started,omitempty"`
+	Duration time.Duration `json:"duration,omitempty"`
+}
+
+// ApplianceDetector turns power readings from one device into the state
+// of the appliance plugged into it.
+type ApplianceDetector struct {
+	Options ApplianceOptions
+	state ApplianceState
+	since time.Time
+	runStart time.Time
+	pending time.Time
+}
+
+func NewApplianceDetector(opts ApplianceOptions) *ApplianceDetector {
+	if opts.StopWatts == 0 {
+		opts.StopWatts = opts.StartWatts * DEFAULT_APPLIANCE_STOP_RATIO
+	}
+	if opts.StopWatts > opts.StartWatts {
+		opts.StopWatts = opts.StartWatts
+	}
+	return &ApplianceDetector{Options: opts, state: ApplianceIdle}
+}
+
+func (d *ApplianceDetector) State() ApplianceState {
+	return d.state
+}
+
+func (d *ApplianceDetector) transition(state ApplianceState, t time.Time, watts float64) *ApplianceEvent {
+	evt := &ApplianceEvent{Previous: d.state, State: state, Time: t, Watts: watts}
+	d.state = state
+	d.since = t
+	d.pending = time.Time{}
+	return evt
+}
+
+// Sample feeds the detector a power reading taken at t.  It returns an
+// event if the reading changed the appliance's state, or nil.  Readings
+// must be given in time order.
+func (d *ApplianceDetector) Sample(t time.Time, watts float64) *ApplianceEvent {
+	opts := &d.Options
+	switch d.state {
+	case ApplianceRunning:
+		if watts > opts.StopWatts {
+			d.pending = time.Time{}
+			return nil
+		}
+		if d.pending.IsZero() {
+			d.pending = t
+		}
+		if t.Sub(d.pending) < opts.MinStopTime {
+			return nil
+		}
+		stopped := d.pending
+		evt := d.transition(ApplianceFinished, t, watts)
+		evt.Started = d.runStart
+		evt.Duration = stopped.Sub(d.runStart)
+		return evt
+	default:
+		if watts < opts.StartWatts {
+			d.pending = time.Time{}
+			if d.state == ApplianceFinished && opts.FinishedTimeout > 0 && t.Sub(d.since) >= opts.FinishedTimeout {
+				return d.transition(ApplianceIdle, t, watts)
+			}
+			return nil
+		}
+		if d.pending.IsZero() {
+			d.pending = t
+		}
+		if t.Sub(d.pending) < opts.MinRunTime {
+			return nil
+		}
+		started := d.pending
+		evt := d.transition(ApplianceRunning, t, watts)
+		d.runStart = started
+		evt.Started = started
+		return evt
+	}
+}
+
+// Run takes a reading from em every Interval and sends the resulting
+// events on the returned channel, until ctx is done.  Failed readings are
+// skipped.
+func (d *ApplianceDetector) Run(ctx context.Context, em *Emeter) chan *ApplianceEvent {
+	interval := d.Options.Interval
+	if interval <= 0 {
+		interval = DEFAULT_APPLIANCE_INTERVAL
+	}
+	ch := make(chan *ApplianceEvent, 1)
+	go func() {
+		defer close(ch)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			rt, err := em.Realtime()
+			if err != nil {
+				if Debug {
+					log.Printf("error reading power from %s: %s", em.Device().Alias(), err)
+				}
+			} else if evt := d.Sample(time.Now(), rt.Watts()); evt != nil {
+				select {
+				case ch <- evt:
+				case <-ctx.Done():
+					return
+				}
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	return ch
+}
+
+// WatchAppliance runs a detector with the given options on dev's energy
+// meter.
+func WatchAppliance(ctx context.Context, dev SmartDevice, opts ApplianceOptions) (chan *ApplianceEvent, error) {
+	em, ok := dev.Module(EmeterModule).(*Emeter)
+	if !ok {
+		return nil, ErrNoEmeter
+	}
+	return NewApplianceDetector(opts).Run(ctx, em), nil
+}
@@ -0,0 +1,132 @@
+package kasa
+
+import (
+	"testing"
+	"time"
+)
+
+func TestApplianceDetectorSample(t *testing.T) {
+	opts := ApplianceOptions{
+		StartWatts: 100,
+		StopWatts: 20,
+		MinRunTime: 30 * time.Second,
+		MinStopTime: time.Minute,
+		FinishedTimeout: 5 * time.Minute,
+	}
+	type sample struct {
+		at int
+		watts float64
+	}
+	// want lists the events expected, with times in seconds from the start
+	type event struct {
+		at int
+		state ApplianceState
+		started int
+		duration int
+	}
+	tests := []struct {
+		name string
+		opts *ApplianceOptions
+		samples []sample
+		want []event
+	}{
+		{
+			name: "spike shorter than min run time",
+			samples: []sample{{0, 150}, {10, 150}, {20, 5}, {60, 150}},
+			want: []event{},
+		},
+		{
+			name: "dip below start restarts min run time",
+			samples: []sample{{0, 150}, {10, 50}, {20, 150}, {40, 150}, {50, 150}},
+			want: []event{{50, ApplianceRunning, 20, 0}},
+		},
+		{
+			name: "between thresholds keeps running",
+			samples: []sample{{0, 150}, {30, 150}, {40, 50}, {400, 50}, {1000, 99}},
+			want: []event{{30, ApplianceRunning, 0, 0}},
+		},
+		{
+			name: "pause shorter than min stop time",
+			samples: []sample{{0, 150}, {30, 150}, {40, 5}, {90, 5}, {95, 150}, {100, 5}, {160, 5}},
+			want: []event{
+				{30, ApplianceRunning, 0, 0},
+				{160, ApplianceFinished, 0, 100},
+			},
+		},
+		{
+			name: "finished times out to idle",
+			samples: []sample{{0, 150}, {30, 150}, {100, 5}, {160, 5}, {459, 5}, {460, 5}},
+			want: []event{
+				{30, ApplianceRunning, 0, 0},
+				{160, ApplianceFinished, 0, 100},
+				{460, ApplianceIdle, 0, 0},
+			},
+		},
+		{
+			name: "runs again after finishing",
+			samples: []sample{{0, 150}, {30, 150}, {100, 5}, {160, 5}, {200, 150}, {230, 150}},
+			want: []event{
+				{30, ApplianceRunning, 0, 0},
+				{160, ApplianceFinished, 0, 100},
+				{230, ApplianceRunning, 200, 0},
+			},
+		},
+		{
+			name: "zero stop watts finishes on standby power",
+			opts: &ApplianceOptions{StartWatts: 100, MinStopTime: time.Minute},
+			samples: []sample{{0, 150}, {10, 3}, {70, 3}},
+			want: []event{
+				{0, ApplianceRunning, 0, 0},
+				{70, ApplianceFinished, 0, 10},
+			},
+		},
+		{
+			name: "stop watts above start watts",
+			opts: &ApplianceOptions{StartWatts: 100, StopWatts: 200},
+			samples: []sample{{0, 150}, {10, 120}, {20, 100}},
+			want: []event{
+				{0, ApplianceRunning, 0, 0},
+				{20, ApplianceFinished, 0, 20},
+			},
+		},
+	}
+	start := time.Date(2024, time.March, 1, 9, 0, 0, 0, time.UTC)
+	at := func(sec int) time.Time {
+		return start.Add(time.Duration(sec) * time.Second)
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			xopts := opts
+			if tc.opts != nil {
+				xopts = *tc.opts
+			}
+			d := NewApplianceDetector(xopts)
+			got := []*ApplianceEvent{}
+			for _, s := range tc.samples {
+				if evt := d.Sample(at(s.at), s.watts); evt != nil {
+					got = append(got, evt)
+				}
+			}
+			if len(got) != len(tc.want) {
+				t.Fatalf("got %d events, want %d: %+v", len(got), len(tc.want), got)
+			}
+			prev := ApplianceIdle
+			for i, want := range tc.want {
+				evt := got[i]
+				if evt.State != want.state || evt.Previous != prev || !evt.Time.Equal(at(want.at)) {
+					t.Errorf("event %d = %s -> %s at %s, want %s -> %s at %s", i, evt.Previous, evt.State, evt.Time, prev, want.state, at(want.at))
+				}
+				if want.state != ApplianceIdle && !evt.Started.Equal(at(want.started)) {
+					t.Errorf("event %d started %s, want %s", i, evt.Started, at(want.started))
+				}
+				if evt.Duration != time.Duration(want.duration) * time.Second {
+					t.Errorf("event %d duration %s, want %ds", i, evt.Duration, want.duration)
+				}
+				prev = want.state
+			}
+			if len(tc.want) > 0 && d.State() != prev {
+				t.Errorf("state = %s, want %s", d.State(), prev)
+			}
+		})
+	}
+}
@@ -1,40 +1,39 @@
 package main
 
 import (
+	"context"
 	"log"
 	"os"
-	"strings"
 	"time"
 
 	"github.com/rclancey/kasa"
 )
 
 func main() {
-	devices, err := kasa.Discover(5 * time.Second)
+	path, err := kasa.DefaultInventoryPath()
 	if err != nil {
 		log.Fatal(err)
 	}
-	count := 0
-	ch := make(chan error, len(devices))
+	inv, devices, err := kasa.LoadOrDiscover(context.Background(), path)
+	if err != nil {
+		log.Fatal(err)
+	}
+	// revalidation gives up after a few seconds, and is what moves
+	// devices that stopped answering at their cached address
+	defer inv.Wait()
+	if len(os.Args) > 1 {
+		devices = kasa.FilterDevices(devices, kasa.MatchAliasPrefix(os.Args[1]))
+	}
+	group := kasa.NewGroup("all")
 	for _, dev := range devices {
-		if len(os.Args) > 1 && !strings.HasPrefix(strings.ToLower(dev.Alias()), strings.ToLower(os.Args[1])) {
-			continue
-		}
-		log.Println("trying to turn on", dev.Alias())
-		plug, isa := dev.(kasa.Switch)
-		if isa && plug.IsOff() {
-			count += 1
-			go func(p kasa.Switch) {
-				err := p.TurnOn()
-				if err != nil {
-					log.Println(err)
-				}
-				ch <- err
-			}(plug)
+		if _, isa := dev.(kasa.Switch); isa {
+			log.Println("trying to turn on", dev.Alias())
+			group.Add(dev)
 		}
 	}
-	for count > 0 {
-		err = <-ch
-		count -= 1
+	ctx, cancel := context.WithTimeout(context.Background(), 30 * time.Second)
+	defer cancel()
+	for id, err := range group.TurnOn(ctx).Failed() {
+		log.Println(id, err)
 	}
 }
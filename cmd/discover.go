@@ -16,11 +16,11 @@ func main() {
 	kasa.Debug = true
 	retry := 10 * time.Second
 	ctx, _ := signal.NotifyContext(context.Background(), os.Interrupt)
-	ch, err := kasa.DiscoverStream(ctx, retry)
+	reg := kasa.NewRegistry(kasa.DEFAULT_LOST_AFTER)
+	ch, err := reg.Run(ctx, retry, nil)
 	if err != nil {
 		log.Fatal(err)
 	}
-	seen := map[string]bool{}
 	wg := &sync.WaitGroup{}
 	wg.Add(1)
 	enc := json.NewEncoder(os.Stdout)
@@ -31,14 +31,23 @@ func main() {
 			select {
 			case <-ctx.Done():
 				return
-			case dev, ok := <-ch:
+			case evt, ok := <-ch:
 				if !ok {
 					return
 				}
-				log.Println(dev.IP(), dev.Alias())
-				if !seen[dev.IP()] {
+				dev := evt.Device
+				switch evt.Type {
+				case kasa.DeviceAdded:
+					log.Println("added", dev.IP(), dev.Alias())
 					enc.Encode(dev)
-					seen[dev.IP()] = true
+				case kasa.DeviceUpdated:
+					if evt.PreviousIP != "" {
+						log.Println("moved", evt.PreviousIP, "=>", dev.IP(), dev.Alias())
+					} else {
+						log.Println("updated", dev.IP(), dev.Alias())
+					}
+				case kasa.DeviceLost:
+					log.Println("lost", dev.IP(), dev.Alias())
 				}
 			}
 		}
@@ -0,0 +1,139 @@
+// Command provision configures factory-reset devices from a manifest.
+//
+// Connect this machine to a device's setup access point and run
+//
+//	provision -manifest devices.csv
+//
+// It waits for a device to answer at the factory address, looks up its MAC
+// address in the manifest, applies the alias, timezone, LED state and
+// schedules listed there, moves the device onto the listed network, and
+// confirms it shows up there.  Then it waits for the next device, until
+// every manifest entry is done or it is interrupted.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/rclancey/kasa"
+)
+
+func main() {
+	manifestFile := flag.String("manifest", "", "manifest file (.csv or .yaml)")
+	addr := flag.String("addr", kasa.FACTORY_ADDR, "address of a device in setup mode")
+	iface := flag.String("iface", "", "interface on the production network, for confirming devices")
+	timeout := flag.Duration("timeout", kasa.DEFAULT_ONBOARD_TIMEOUT, "how long to wait for each device on the production network")
+	poll := flag.Duration("poll", 2 * time.Second, "how often to look for the next device")
+	debug := flag.Bool("debug", false, "log protocol details")
+	flag.Parse()
+	if *manifestFile == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+	kasa.Debug = *debug
+	manifest, err := LoadManifest(*manifestFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+	opts := &kasa.OnboardOptions{Timeout: *timeout}
+	if *iface != "" {
+		opts.Discover = &kasa.DiscoverOptions{Interface: *iface}
+	}
+	done := map[string]bool{}
+	failed := 0
+	log.Printf("waiting for %d devices at %s", len(manifest), *addr)
+	for len(done) < len(manifest) {
+		dev, err := kasa.NewDevice(*addr)
+		if err != nil {
+			select {
+			case <-ctx.Done():
+				log.Printf("interrupted with %d of %d devices provisioned", len(done), len(manifest))
+				os.Exit(1)
+			case <-time.After(*poll):
+			}
+			continue
+		}
+		mac := normalizeMAC(dev.MAC())
+		entry := manifest.Lookup(mac)
+		if entry == nil || done[mac] {
+			log.Printf("%s (%s) is not in the manifest or already done; connect to another device", dev.MAC(), dev.Model())
+			time.Sleep(*poll)
+			continue
+		}
+		found, err := provision(ctx, dev, entry, opts)
+		if err != nil {
+			log.Printf("failed to provision %s: %s", entry.MAC, err)
+			failed++
+			time.Sleep(*poll)
+			continue
+		}
+		done[mac] = true
+		log.Printf("%s is %q at %s (%d of %d)", entry.MAC, found.Alias(), found.IP(), len(done), len(manifest))
+	}
+	if failed > 0 {
+		log.Printf("%d attempts failed along the way", failed)
+	}
+}
+
+func provision(ctx context.Context, dev kasa.SmartDevice, entry *Entry, opts *kasa.OnboardOptions) (kasa.SmartDevice, error) {
+	if entry.Alias != "" {
+		err := dev.SetAlias(entry.Alias)
+		if err != nil {
+			return nil, fmt.Errorf("can't set alias: %w", err)
+		}
+	}
+	if entry.Timezone != "" {
+		tz, _ := entry.timezone()
+		err := dev.SetTimezone(tz)
+		if err != nil {
+			return nil, fmt.Errorf("can't set timezone: %w", err)
+		}
+	}
+	if entry.LED != nil && dev.HasLED() {
+		err := dev.SetLED(*entry.LED)
+		if err != nil {
+			return nil, fmt.Errorf("can't set LED: %w", err)
+		}
+	}
+	rules, _ := entry.rules()
+	if len(rules) > 0 {
+		sched, ok := dev.Module(kasa.ScheduleModule).(*kasa.Schedule)
+		if !ok {
+			return nil, fmt.Errorf("device has no schedule")
+		}
+		err := sched.DeleteAllRules()
+		if err != nil {
+			return nil, fmt.Errorf("can't clear schedule: %w", err)
+		}
+		for _, rule := range rules {
+			_, err = sched.AddRule(rule)
+			if err != nil {
+				return nil, fmt.Errorf("can't add schedule %q: %w", rule.Name, err)
+			}
+		}
+		err = sched.SetEnabled(true)
+		if err != nil {
+			return nil, fmt.Errorf("can't enable schedule: %w", err)
+		}
+	}
+	password := entry.Password
+	if password == "" {
+		password = os.Getenv("KASA_WIFI_PASSWORD")
+	}
+	log.Printf("moving %s to %s", entry.MAC, entry.SSID)
+	found, err := kasa.Onboard(ctx, dev, entry.SSID, password, opts)
+	if err != nil {
+		return nil, err
+	}
+	if entry.Alias != "" && found.Alias() != entry.Alias {
+		return found, fmt.Errorf("found at %s with alias %q", found.IP(), found.Alias())
+	}
+	return found, nil
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rclancey/kasa"
+	"gopkg.in/yaml.v3"
+)
+
+// Entry is the configuration for one device, keyed by its MAC address.
+// Schedules are written like "on 07:00 weekdays" or "off 23:30 sat,sun".
+type Entry struct {
+	MAC string `yaml:"mac"`
+	Alias string `yaml:"alias"`
+	SSID string `yaml:"ssid"`
+	Password string `yaml:"password"`
+	Timezone string `yaml:"timezone"`
+	LED *bool `yaml:"led"`
+	Schedules []string `yaml:"schedules"`
+}
+
+type Manifest map[string]*Entry
+
+func normalizeMAC(mac string) string {
+	mac = strings.ToUpper(mac)
+	mac = strings.ReplaceAll(mac, ":", "")
+	return strings.ReplaceAll(mac, "-", "")
+}
+
+func (m Manifest) Lookup(mac string) *Entry {
+	return m[normalizeMAC(mac)]
+}
+
+// LoadManifest reads a manifest from a .csv or .yaml file.  CSV files need
+// a header row naming the columns; multiple schedules in one cell are
+// separated by semicolons.
+func LoadManifest(fn string) (Manifest, error) {
+	f, err := os.Open(fn)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var entries []*Entry
+	switch strings.ToLower(filepath.Ext(fn)) {
+	case ".csv":
+		entries, err = readCSV(f)
+	case ".yaml", ".yml":
+		err = yaml.NewDecoder(f).Decode(&entries)
+	default:
+		err = fmt.Errorf("unknown manifest type %s", filepath.Ext(fn))
+	}
+	if err != nil {
+		return nil, err
+	}
+	m := Manifest{}
+	for i, entry := range entries {
+		err = entry.validate()
+		if err != nil {
+			return nil, fmt.Errorf("entry %d: %w", i + 1, err)
+		}
+		m[normalizeMAC(entry.MAC)] = entry
+	}
+	return m, nil
+}
+
+func readCSV(r io.Reader) ([]*Entry, error) {
+	rows, err := csv.NewReader(r).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return nil, nil
+	}
+	cols := map[string]int{}
+	for i, name := range rows[0] {
+		cols[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	get := func(row []string, name string) string {
+		i, ok := cols[name]
+		if !ok || i >= len(row) {
+			return ""
+		}
+		return strings.TrimSpace(row[i])
+	}
+	entries := []*Entry{}
+	for _, row := range rows[1:] {
+		entry := &Entry{
+			MAC: get(row, "mac"),
+			Alias: get(row, "alias"),
+			SSID: get(row, "ssid"),
+			Password: get(row, "password"),
+			Timezone: get(row, "timezone"),
+		}
+		switch strings.ToLower(get(row, "led")) {
+		case "":
+		case "on", "true", "yes", "1":
+			led := true
+			entry.LED = &led
+		case "off", "false", "no", "0":
+			led := false
+			entry.LED = &led
+		default:
+			return nil, fmt.Errorf("bad led value for %s: %s", entry.MAC, get(row, "led"))
+		}
+		for _, sched := range strings.Split(get(row, "schedules"), ";") {
+			if sched = strings.TrimSpace(sched); sched != "" {
+				entry.Schedules = append(entry.Schedules, sched)
+			}
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+func (entry *Entry) validate() error {
+	if entry.MAC == "" {
+		return fmt.Errorf("missing mac")
+	}
+	if entry.SSID == "" {
+		return fmt.Errorf("missing ssid for %s", entry.MAC)
+	}
+	if entry.Timezone != "" {
+		_, err := entry.timezone()
+		if err != nil {
+			return err
+		}
+	}
+	_, err := entry.rules()
+	return err
+}
+
+// timezone accepts a device timezone index or a location name.
+func (entry *Entry) timezone() (kasa.Timezone, error) {
+	if idx, err := strconv.Atoi(entry.Timezone); err == nil {
+		return kasa.Timezone(idx), nil
+	}
+	tz, ok := kasa.LookupTimezone(entry.Timezone)
+	if !ok {
+		return 0, fmt.Errorf("unknown timezone for %s: %s", entry.MAC, entry.Timezone)
+	}
+	return tz, nil
+}
+
+func (entry *Entry) rules() ([]*kasa.Rule, error) {
+	rules := make([]*kasa.Rule, len(entry.Schedules))
+	for i, sched := range entry.Schedules {
+		rule, err := parseSchedule(sched)
+		if err != nil {
+			return nil, fmt.Errorf("bad schedule for %s: %w", entry.MAC, err)
+		}
+		rules[i] = rule
+	}
+	return rules, nil
+}
+
+var dayNames = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"sunday": time.Sunday,
+	"mon": time.Monday,
+	"monday": time.Monday,
+	"tue": time.Tuesday,
+	"tues": time.Tuesday,
+	"tuesday": time.Tuesday,
+	"wed": time.Wednesday,
+	"wednesday": time.Wednesday,
+	"thu": time.Thursday,
+	"thur": time.Thursday,
+	"thurs": time.Thursday,
+	"thursday": time.Thursday,
+	"fri": time.Friday,
+	"friday": time.Friday,
+	"sat": time.Saturday,
+	"saturday": time.Saturday,
+}
+
+func parseDay(s string) (time.Weekday, error) {
+	day, ok := dayNames[strings.ToLower(s)]
+	if !ok {
+		return 0, fmt.Errorf("unknown day %s", s)
+	}
+	return day, nil
+}
+
+// parseDays reads "daily", "weekdays", "weekends", or a comma separated
+// list of days and day ranges such as "mon-wed,fri".
+func parseDays(s string) ([]time.Weekday, error) {
+	switch strings.ToLower(s) {
+	case "", "daily":
+		return nil, nil
+	case "weekdays":
+		return []time.Weekday{time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday}, nil
+	case "weekends":
+		return []time.Weekday{time.Saturday, time.Sunday}, nil
+	}
+	days := []time.Weekday{}
+	for _, part := range strings.Split(s, ",") {
+		parts := strings.SplitN(part, "-", 2)
+		first, err := parseDay(parts[0])
+		if err != nil {
+			return nil, err
+		}
+		last := first
+		if len(parts) == 2 {
+			last, err = parseDay(parts[1])
+			if err != nil {
+				return nil, err
+			}
+		}
+		for day := first; ; day = (day + 1) % 7 {
+			days = append(days, day)
+			if day == last {
+				break
+			}
+		}
+	}
+	return days, nil
+}
+
+func parseSchedule(s string) (*kasa.Rule, error) {
+	fields := strings.Fields(s)
+	if len(fields) < 2 || len(fields) > 3 {
+		return nil, fmt.Errorf("expected \"on|off HH:MM [days]\", got %q", s)
+	}
+	var on bool
+	switch strings.ToLower(fields[0]) {
+	case "on":
+		on = true
+	case "off":
+		on = false
+	default:
+		return nil, fmt.Errorf("unknown action %s", fields[0])
+	}
+	t, err := time.Parse("15:04", fields[1])
+	if err != nil {
+		return nil, err
+	}
+	at := time.Duration(t.Hour()) * time.Hour + time.Duration(t.Minute()) * time.Minute
+	var days []time.Weekday
+	if len(fields) == 3 {
+		days, err = parseDays(fields[2])
+		if err != nil {
+			return nil, err
+		}
+	}
+	return kasa.NewDailyRule(s, at, on, days...), nil
+}
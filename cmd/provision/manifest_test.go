@@ -0,0 +1,98 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/rclancey/kasa"
+)
+
+func TestParseDays(t *testing.T) {
+	tests := []struct {
+		in string
+		days []time.Weekday
+		err bool
+	}{
+		{"", nil, false},
+		{"daily", nil, false},
+		{"Daily", nil, false},
+		{"weekdays", []time.Weekday{time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday}, false},
+		{"weekends", []time.Weekday{time.Saturday, time.Sunday}, false},
+		{"mon", []time.Weekday{time.Monday}, false},
+		{"Monday,Friday", []time.Weekday{time.Monday, time.Friday}, false},
+		{"mon-wed,fri", []time.Weekday{time.Monday, time.Tuesday, time.Wednesday, time.Friday}, false},
+		{"fri-mon", []time.Weekday{time.Friday, time.Saturday, time.Sunday, time.Monday}, false},
+		{"sun-sun", []time.Weekday{time.Sunday}, false},
+		{"TUESDAY,thurs", []time.Weekday{time.Tuesday, time.Thursday}, false},
+		{"funday", nil, true},
+		{"monster", nil, true},
+		{"sunburn-tue", nil, true},
+		{"ẞ", nil, true},
+		{"mon-ẞẞ", nil, true},
+		{"m", nil, true},
+		{"mon-", nil, true},
+		{"mon,,tue", nil, true},
+		{"mon-xyz", nil, true},
+	}
+	for _, tc := range tests {
+		days, err := parseDays(tc.in)
+		if tc.err {
+			if err == nil {
+				t.Errorf("parseDays(%q) = %v, want error", tc.in, days)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseDays(%q): %s", tc.in, err)
+			continue
+		}
+		if !reflect.DeepEqual(days, tc.days) {
+			t.Errorf("parseDays(%q) = %v, want %v", tc.in, days, tc.days)
+		}
+	}
+}
+
+func TestParseSchedule(t *testing.T) {
+	tests := []struct {
+		in string
+		minute int
+		action int
+		wday []int
+		err bool
+	}{
+		{in: "on 07:30", minute: 450, action: kasa.RuleActionOn, wday: []int{1, 1, 1, 1, 1, 1, 1}},
+		{in: "OFF 23:05 daily", minute: 1385, action: kasa.RuleActionOff, wday: []int{1, 1, 1, 1, 1, 1, 1}},
+		{in: "on 00:00 weekdays", minute: 0, action: kasa.RuleActionOn, wday: []int{0, 1, 1, 1, 1, 1, 0}},
+		{in: "off 18:00 weekends", minute: 1080, action: kasa.RuleActionOff, wday: []int{1, 0, 0, 0, 0, 0, 1}},
+		{in: "  on   6:15   sat-mon ", minute: 375, action: kasa.RuleActionOn, wday: []int{1, 1, 0, 0, 0, 0, 1}},
+		{in: "on", err: true},
+		{in: "toggle 07:30", err: true},
+		{in: "on 24:00", err: true},
+		{in: "on 7:30pm", err: true},
+		{in: "on 07:30 someday", err: true},
+		{in: "on 07:30 mon tue", err: true},
+	}
+	for _, tc := range tests {
+		rule, err := parseSchedule(tc.in)
+		if tc.err {
+			if err == nil {
+				t.Errorf("parseSchedule(%q) = %+v, want error", tc.in, rule)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseSchedule(%q): %s", tc.in, err)
+			continue
+		}
+		if rule.StartMinute != tc.minute || rule.StartAction != tc.action || rule.Enable != 1 {
+			t.Errorf("parseSchedule(%q) = %d min action %d enable %d, want %d min action %d", tc.in, rule.StartMinute, rule.StartAction, rule.Enable, tc.minute, tc.action)
+		}
+		if !reflect.DeepEqual(rule.WeekDays, tc.wday) {
+			t.Errorf("parseSchedule(%q) days = %v, want %v", tc.in, rule.WeekDays, tc.wday)
+		}
+		if rule.Name != tc.in {
+			t.Errorf("parseSchedule(%q) name = %q", tc.in, rule.Name)
+		}
+	}
+}
@@ -2,20 +2,25 @@ package main
 
 import (
 	"bufio"
+	"context"
 	"fmt"
 	"log"
 	"os"
 	"strings"
-	"time"
 
 	"github.com/rclancey/kasa"
 )
 
 func main() {
-	devices, err := kasa.Discover(5 * time.Second)
+	path, err := kasa.DefaultInventoryPath()
 	if err != nil {
 		log.Fatal(err)
 	}
+	inv, devices, err := kasa.LoadOrDiscover(context.Background(), path)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer inv.Wait()
 	for {
 		for i, dev := range devices {
 			fmt.Printf("%d: %s\n", i + 1, dev.Alias())
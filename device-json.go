@@ -0,0 +1,210 @@
+package kasa
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// deviceJSON is the serialized form of every concrete device type.  Type
+// says which Go type to rebuild on the way back in.
+type deviceJSON struct {
+	Type DeviceType `json:"type"`
+	Addr string `json:"addr"`
+	Info *Query `json:"info"`
+	ChildID string `json:"child_id,omitempty"`
+	Discovery *DiscoveryResult `json:"discovery,omitempty"`
+}
+
+func (dev *BaseDevice) toJSON(devType DeviceType) *deviceJSON {
+	return &deviceJSON{
+		Type: devType,
+		Addr: dev.IP(),
+		Info: dev.getInfo(),
+	}
+}
+
+func (dj *deviceJSON) base() *BaseDevice {
+	return &BaseDevice{Addr: dj.Addr, Info: dj.Info}
+}
+
+func decodeDeviceJSON(data []byte, want DeviceType) (*deviceJSON, error) {
+	dj := &deviceJSON{}
+	err := json.Unmarshal(data, dj)
+	if err != nil {
+		return nil, err
+	}
+	if want != "" && dj.Type != "" && dj.Type != want {
+		return nil, fmt.Errorf("can't decode %s as %s", dj.Type, want)
+	}
+	return dj, nil
+}
+
+// UnmarshalDevice decodes any device encoded with json.Marshal back into
+// its concrete type.
+func UnmarshalDevice(data []byte) (SmartDevice, error) {
+	dj, err := decodeDeviceJSON(data, "")
+	if err != nil {
+		return nil, err
+	}
+	var dev SmartDevice
+	switch dj.Type {
+	case DeviceTypePlug:
+		dev = &SmartPlug{}
+	case DeviceTypeStrip:
+		dev = &SmartStrip{}
+	case DeviceTypeStripSocket:
+		dev = &SmartStripSocket{}
+	case DeviceTypeBulb:
+		dev = &SmartBulb{}
+	case DeviceTypeLightStrip:
+		dev = &SmartLightStrip{}
+	case DeviceTypeDimmer:
+		dev = &SmartDimmer{}
+	case DeviceTypeEncrypted:
+		dev = &EncryptedDevice{}
+	case "", DeviceTypeUnknown:
+		// no usable discriminator; work it out from the sysinfo
+		base := dj.base()
+		return base.AsConcrete(), nil
+	default:
+		return nil, fmt.Errorf("unknown device type '%s'", dj.Type)
+	}
+	err = json.Unmarshal(data, dev)
+	if err != nil {
+		return nil, err
+	}
+	return dev, nil
+}
+
+func (dev *BaseDevice) MarshalJSON() ([]byte, error) {
+	return json.Marshal(dev.toJSON(DeviceTypeUnknown))
+}
+
+func (dev *BaseDevice) UnmarshalJSON(data []byte) error {
+	dj, err := decodeDeviceJSON(data, "")
+	if err != nil {
+		return err
+	}
+	dev.setAddrInfo(dj.Addr, dj.Info)
+	dev.self = dev
+	return nil
+}
+
+func (dev *BaseDevice) setAddrInfo(addr string, info *Query) {
+	dev.lock.Lock()
+	defer dev.lock.Unlock()
+	dev.Addr = addr
+	dev.Info = info
+}
+
+func (plug *SmartPlug) MarshalJSON() ([]byte, error) {
+	return json.Marshal(plug.BaseDevice.toJSON(DeviceTypePlug))
+}
+
+func (plug *SmartPlug) UnmarshalJSON(data []byte) error {
+	dj, err := decodeDeviceJSON(data, DeviceTypePlug)
+	if err != nil {
+		return err
+	}
+	plug.BaseDevice = dj.base()
+	plug.self = plug
+	return nil
+}
+
+func (strip *SmartStrip) MarshalJSON() ([]byte, error) {
+	return json.Marshal(strip.BaseDevice.toJSON(DeviceTypeStrip))
+}
+
+func (strip *SmartStrip) UnmarshalJSON(data []byte) error {
+	dj, err := decodeDeviceJSON(data, DeviceTypeStrip)
+	if err != nil {
+		return err
+	}
+	strip.BaseDevice = dj.base()
+	strip.self = strip
+	return nil
+}
+
+func (plug *SmartStripSocket) MarshalJSON() ([]byte, error) {
+	dj := plug.BaseDevice.toJSON(DeviceTypeStripSocket)
+	dj.ChildID = plug.id
+	return json.Marshal(dj)
+}
+
+func (plug *SmartStripSocket) UnmarshalJSON(data []byte) error {
+	dj, err := decodeDeviceJSON(data, DeviceTypeStripSocket)
+	if err != nil {
+		return err
+	}
+	strip := &SmartStrip{BaseDevice: dj.base()}
+	strip.self = strip
+	plug.SmartStrip = strip
+	plug.id = dj.ChildID
+	return nil
+}
+
+func (bulb *SmartBulb) MarshalJSON() ([]byte, error) {
+	return json.Marshal(bulb.BaseDevice.toJSON(DeviceTypeBulb))
+}
+
+func (bulb *SmartBulb) UnmarshalJSON(data []byte) error {
+	dj, err := decodeDeviceJSON(data, DeviceTypeBulb)
+	if err != nil {
+		return err
+	}
+	bulb.BaseDevice = dj.base()
+	bulb.self = bulb
+	return nil
+}
+
+func (strip *SmartLightStrip) MarshalJSON() ([]byte, error) {
+	return json.Marshal(strip.BaseDevice.toJSON(DeviceTypeLightStrip))
+}
+
+func (strip *SmartLightStrip) UnmarshalJSON(data []byte) error {
+	dj, err := decodeDeviceJSON(data, DeviceTypeLightStrip)
+	if err != nil {
+		return err
+	}
+	strip.BaseDevice = dj.base()
+	strip.self = strip
+	return nil
+}
+
+func (dimmer *SmartDimmer) MarshalJSON() ([]byte, error) {
+	return json.Marshal(dimmer.BaseDevice.toJSON(DeviceTypeDimmer))
+}
+
+func (dimmer *SmartDimmer) UnmarshalJSON(data []byte) error {
+	dj, err := decodeDeviceJSON(data, DeviceTypeDimmer)
+	if err != nil {
+		return err
+	}
+	dimmer.BaseDevice = dj.base()
+	dimmer.self = dimmer
+	return nil
+}
+
+func (dev *EncryptedDevice) MarshalJSON() ([]byte, error) {
+	dj := dev.BaseDevice.toJSON(DeviceTypeEncrypted)
+	dj.Discovery = dev.Discovery
+	return json.Marshal(dj)
+}
+
+func (dev *EncryptedDevice) UnmarshalJSON(data []byte) error {
+	dj, err := decodeDeviceJSON(data, DeviceTypeEncrypted)
+	if err != nil {
+		return err
+	}
+	if dj.Discovery == nil {
+		return fmt.Errorf("encrypted device has no discovery result")
+	}
+	xdev := newEncryptedDevice(dj.Addr, dj.Discovery)
+	dev.BaseDevice = xdev.BaseDevice
+	dev.Discovery = xdev.Discovery
+	dev.self = dev
+	if dj.Info != nil {
+		dev.setAddrInfo(dj.Addr, dj.Info)
+	}
+	return nil
+}
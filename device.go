@@ -2,9 +2,12 @@ package kasa
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
 	"math"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -36,6 +39,7 @@ type LightState struct {
 type SysInfo struct {
 	ActiveMode string `json:"active_mode,omitempty"`
 	Alias string `json:"alias,omitempty"`
+	Brightness int `json:"brightness,omitempty"`
 	ChildNum int `json:"child_num,omitempty"`
 	DeviceName string `json:"dev_name,omitempty"`
 	DeviceID string `json:"deviceId,omitempty"`
@@ -55,6 +59,7 @@ type SysInfo struct {
 	LightState *LightState `json:"light_state,omitempty"`
 	Longitude int `json:"longitude_i,omitempty"`
 	MACAddr string `json:"mac,omitempty"`
+	MicMACAddr string `json:"mic_mac,omitempty"`
 	MicType string `json:"mic_type,omitempty"`
 	Model string `json:"model,omitempty"`
 	NextAction *Action `json:"next_action,omitempty"`
@@ -70,6 +75,7 @@ type SysInfo struct {
 	Updating int `json:"updating,omitempty"`
 	Children []*SysInfo `json:"children,omitempty"`
 	LastUpdate time.Time `json:"last_update,omitempty"`
+	raw map[string]json.RawMessage
 }
 
 type TimeInfo struct {
@@ -107,9 +113,33 @@ type SysInfoResponse struct {
 	SysInfo *SysInfo `json:"get_sysinfo,omitempty"`
 }
 
+// WifiKeyType is the kind of security a wireless network uses.
+type WifiKeyType int
+
+const (
+	WifiKeyNone = WifiKeyType(0)
+	WifiKeyWEP = WifiKeyType(1)
+	WifiKeyWPA = WifiKeyType(2)
+	WifiKeyWPA2 = WifiKeyType(3)
+)
+
+func (kt WifiKeyType) String() string {
+	switch kt {
+	case WifiKeyNone:
+		return "none"
+	case WifiKeyWEP:
+		return "WEP"
+	case WifiKeyWPA:
+		return "WPA"
+	case WifiKeyWPA2:
+		return "WPA2"
+	}
+	return fmt.Sprintf("WifiKeyType(%d)", int(kt))
+}
+
 type WifiAP struct {
 	SSID string `json:"ssid"`
-	KeyType int `json:"key_type"`
+	KeyType WifiKeyType `json:"key_type"`
 	Password string `json:"password,omitempty"`
 }
 
@@ -151,6 +181,8 @@ type SmartDevice interface {
 	Reboot(time.Duration) error
 	SetAlias(string) error
 	SetMAC(string) error
+	SetLED(bool) error
+	SetTimezone(Timezone) error
 	Alias() string
 	DeviceID() string
 	DeviceName() string
@@ -167,15 +199,23 @@ type SmartDevice interface {
 	IsStrip() bool
 	IsStripSocket() bool
 	IsVariableColorTemp() bool
+	Capabilities() *ModelInfo
+	HasEmeter() bool
+	HasLED() bool
+	Module(ModuleName) Module
+	Modules() []Module
 	Location() *LatLon
 	MAC() string
 	Model() string
 	OnSince() *time.Time
 	RSSI() int
+	Freshness() time.Duration
 
 	GetLightService() string
 	GetTimeService() string
 	Repl(string) (string, error)
+	WifiScan() (*WifiScanInfo, error)
+	WifiJoin(ssid, password string, keytype ...WifiKeyType) error
 }
 
 type Switch interface {
@@ -193,7 +233,13 @@ type BaseDevice struct {
 	Addr string `json:"addr"`
 	Info *Query `json:"info"`
 	self SmartDevice
-	Responses []interface{}
+	history *QueryHistory
+	retry *RetryPolicy
+	transport Transport
+	maxAge time.Duration
+	lastRefresh time.Time
+	lock sync.RWMutex
+	refreshLock sync.Mutex
 }
 
 func NewDevice(addr string) (SmartDevice, error) {
@@ -206,10 +252,42 @@ func NewDevice(addr string) (SmartDevice, error) {
 	return dev.AsConcrete(), nil
 }
 
+func baseDevice(dev SmartDevice) *BaseDevice {
+	switch xdev := dev.(type) {
+	case *BaseDevice:
+		return xdev
+	case *SmartPlug:
+		return xdev.BaseDevice
+	case *SmartStrip:
+		return xdev.BaseDevice
+	case *SmartStripSocket:
+		return xdev.BaseDevice
+	case *SmartBulb:
+		return xdev.BaseDevice
+	case *SmartLightStrip:
+		return xdev.BaseDevice
+	case *SmartDimmer:
+		return xdev.BaseDevice
+	case *EncryptedDevice:
+		return xdev.BaseDevice
+	}
+	return nil
+}
+
 func (dev *BaseDevice) IP() string {
+	dev.lock.RLock()
+	defer dev.lock.RUnlock()
 	return dev.Addr
 }
 
+// SetIP points the device at a new address, e.g. after its DHCP lease
+// changed.
+func (dev *BaseDevice) SetIP(addr string) {
+	dev.lock.Lock()
+	defer dev.lock.Unlock()
+	dev.Addr = addr
+}
+
 func (dev *BaseDevice) AsConcrete() SmartDevice {
 	switch dev.DeviceType() {
 	case DeviceTypeDimmer:
@@ -261,7 +339,7 @@ func (dev *BaseDevice) Repl(request string) (string, error) {
 	if err != nil {
 		return "", err
 	}
-	err = query(dev.Addr, req, &res)
+	err = dev.GetTransport().Query(dev.IP(), req, &res)
 	if err != nil {
 		return "", err
 	}
@@ -272,23 +350,73 @@ func (dev *BaseDevice) Repl(request string) (string, error) {
 	return string(data), nil
 }
 
+func (dev *BaseDevice) SetRetryPolicy(policy *RetryPolicy) {
+	dev.lock.Lock()
+	defer dev.lock.Unlock()
+	dev.retry = policy
+}
+
+func (dev *BaseDevice) RetryPolicy() *RetryPolicy {
+	dev.lock.RLock()
+	defer dev.lock.RUnlock()
+	if dev.retry == nil {
+		return DefaultRetryPolicy
+	}
+	return dev.retry
+}
+
+func (dev *BaseDevice) SetTransport(transport Transport) {
+	dev.lock.Lock()
+	defer dev.lock.Unlock()
+	dev.transport = transport
+}
+
+func (dev *BaseDevice) GetTransport() Transport {
+	dev.lock.RLock()
+	defer dev.lock.RUnlock()
+	if dev.transport == nil {
+		return TCPTransport
+	}
+	return dev.transport
+}
+
+// EnableHistory keeps a record of the last size queries made to the
+// device.  A size of zero or less disables it.
+func (dev *BaseDevice) EnableHistory(size int) {
+	dev.lock.Lock()
+	defer dev.lock.Unlock()
+	if size <= 0 {
+		dev.history = nil
+	} else {
+		dev.history = NewQueryHistory(size)
+	}
+}
+
+// History returns the device's query history, or nil if it isn't enabled.
+func (dev *BaseDevice) History() *QueryHistory {
+	dev.lock.RLock()
+	defer dev.lock.RUnlock()
+	return dev.history
+}
+
 func (dev *BaseDevice) Query(res interface{}, target, cmd string, arg interface{}, childIds ...interface{}) error {
 	req := dev.makeQuery(target, cmd, arg, childIds...)
-	dev.Responses = append(dev.Responses, res)
-	var err error
-	// retry up to 3 times
-	for i := 0; i < 3; i += 1 {
-		if i > 0 {
-			time.Sleep(time.Second)
-		}
-		err = query(dev.Addr, req, res)
-		if err == nil {
-			return nil
-		}
-		_, isa := err.(*netError)
-		if !isa {
-			return err
-		}
+	transport := dev.GetTransport()
+	addr := dev.IP()
+	start := time.Now()
+	err := dev.RetryPolicy().Do(func() error {
+		return transport.Query(addr, req, res)
+	})
+	if history := dev.History(); history != nil {
+		history.Add(QueryRecord{
+			Time: start,
+			Duration: time.Since(start),
+			Target: target,
+			Command: cmd,
+			Request: req,
+			Response: res,
+			Error: err,
+		})
 	}
 	return err
 }
@@ -299,26 +427,154 @@ func (dev *BaseDevice) Update() error {
 	if err != nil {
 		return err
 	}
-	dev.Info = res
-	dev.setUpdateTime()
+	res.setUpdateTime()
+	dev.setInfo(res)
 	return nil
 }
 
-func (dev *BaseDevice) setUpdateTime() {
-	sysinfo := dev.GetSysInfo()
-	sysinfo.LastUpdate = time.Now().In(time.UTC)
+func (info *Query) setUpdateTime() {
+	if info.System == nil || info.System.SysInfo == nil {
+		return
+	}
+	info.System.SysInfo.LastUpdate = time.Now().In(time.UTC)
+}
+
+func (dev *BaseDevice) getInfo() *Query {
+	dev.lock.RLock()
+	defer dev.lock.RUnlock()
+	return dev.Info
+}
+
+// setInfo replaces the device's cached state.  The Query and everything
+// it points to must not be modified once it has been handed over, since
+// other goroutines may be reading it.
+func (dev *BaseDevice) setInfo(info *Query) {
+	dev.lock.Lock()
+	defer dev.lock.Unlock()
+	dev.Info = info
+}
+
+// SetMaxAge makes the device refresh its sysinfo automatically whenever
+// it is read and the cached copy is older than maxAge.  Zero disables
+// automatic refreshes.
+func (dev *BaseDevice) SetMaxAge(maxAge time.Duration) {
+	dev.lock.Lock()
+	defer dev.lock.Unlock()
+	dev.maxAge = maxAge
+}
+
+func (dev *BaseDevice) MaxAge() time.Duration {
+	dev.lock.RLock()
+	defer dev.lock.RUnlock()
+	return dev.maxAge
+}
+
+func (dev *BaseDevice) cachedSysInfo() *SysInfo {
+	info := dev.getInfo()
+	if info == nil || info.System == nil {
+		return nil
+	}
+	return info.System.SysInfo
+}
+
+func (dev *BaseDevice) isStale() bool {
+	maxAge := dev.MaxAge()
+	if maxAge <= 0 {
+		return false
+	}
+	// an unreachable device is only retried once per maxAge, rather than
+	// on every accessor call
+	dev.lock.RLock()
+	last := dev.lastRefresh
+	dev.lock.RUnlock()
+	if sysinfo := dev.cachedSysInfo(); sysinfo != nil && sysinfo.LastUpdate.After(last) {
+		last = sysinfo.LastUpdate
+	}
+	return time.Since(last) > maxAge
+}
+
+func (dev *BaseDevice) refreshIfStale() {
+	if !dev.isStale() {
+		return
+	}
+	dev.refreshLock.Lock()
+	defer dev.refreshLock.Unlock()
+	// someone else may have refreshed while we waited for the lock
+	if !dev.isStale() {
+		return
+	}
+	dev.lock.Lock()
+	dev.lastRefresh = time.Now()
+	dev.lock.Unlock()
+	err := dev.Update()
+	if err != nil {
+		log.Println("error refreshing sysinfo:", err)
+	}
 }
 
 func (dev *BaseDevice) GetSysInfo() *SysInfo {
-	if dev.Info == nil || dev.Info.System == nil {
+	dev.refreshIfStale()
+	return dev.cachedSysInfo()
+}
+
+// Freshness returns how long ago the cached sysinfo was fetched from the
+// device, or -1 if it never has been.
+func (dev *BaseDevice) Freshness() time.Duration {
+	sysinfo := dev.cachedSysInfo()
+	if sysinfo == nil || sysinfo.LastUpdate.IsZero() {
+		return -1
+	}
+	return time.Since(sysinfo.LastUpdate)
+}
+
+// patchSysInfo applies a local change to a copy of the cached sysinfo and
+// swaps it in, so state reflects a successful command without waiting for
+// the next Update.
+func (dev *BaseDevice) patchSysInfo(fn func(sysinfo *SysInfo)) {
+	dev.lock.Lock()
+	defer dev.lock.Unlock()
+	if dev.Info == nil || dev.Info.System == nil || dev.Info.System.SysInfo == nil {
+		return
+	}
+	info := *dev.Info
+	info.System = &SysInfoResponse{SysInfo: dev.Info.System.SysInfo.clone()}
+	fn(info.System.SysInfo)
+	dev.Info = &info
+}
+
+func (sysinfo *SysInfo) clone() *SysInfo {
+	if sysinfo == nil {
 		return nil
 	}
-	return dev.Info.System.SysInfo
+	xinfo := *sysinfo
+	if sysinfo.LightState != nil {
+		light := *sysinfo.LightState
+		xinfo.LightState = &light
+	}
+	if sysinfo.Children != nil {
+		xinfo.Children = make([]*SysInfo, len(sysinfo.Children))
+		for i, child := range sysinfo.Children {
+			xinfo.Children[i] = child.clone()
+		}
+	}
+	return &xinfo
 }
 
 
 func (dev *BaseDevice) GetCurrentConsumption() (float64, error) {
-	return 0, nil
+	return currentConsumption(dev.concrete())
+}
+
+func currentConsumption(dev SmartDevice) (float64, error) {
+	em, ok := dev.Module(EmeterModule).(*Emeter)
+	if !ok {
+		return 0, nil
+	}
+	rt, err := em.Realtime()
+	if err != nil {
+		return 0, err
+	}
+	return rt.Watts(), nil
 }
 
 func (dev *BaseDevice) GetTime() (time.Time, error) {
@@ -374,6 +630,37 @@ func (dev *BaseDevice) SetAlias(alias string) error {
 	return nil
 }
 
+// SetLED turns the device's status LED on or off.  The LED on a strip is
+// shared by all of its sockets.
+func (dev *BaseDevice) SetLED(on bool) error {
+	if !dev.HasLED() {
+		return errors.New("device has no LED")
+	}
+	off := 1
+	if on {
+		off = 0
+	}
+	m := &ModuleBase{target: "system", dev: dev.concrete(), base: dev}
+	err := m.Query(nil, "set_led_off", map[string]interface{}{"off": off})
+	if err != nil {
+		return err
+	}
+	dev.patchSysInfo(func(sysinfo *SysInfo) {
+		sysinfo.LEDOff = off
+	})
+	return nil
+}
+
+// SetTimezone sets the timezone the device uses for its clock and
+// schedules.
+func (dev *BaseDevice) SetTimezone(tz Timezone) error {
+	tm, ok := dev.Module(TimeModule).(*Time)
+	if !ok {
+		return errNoModule(dev.concrete(), TimeModule)
+	}
+	return tm.SetTimezone(tz)
+}
+
 type SetMACRequest struct {
 	MAC string `json:"mac"`
 }
@@ -409,60 +696,52 @@ func (dev *BaseDevice) Reboot(delay time.Duration) error {
 	return nil
 }
 
-func (dev *BaseDevice) WifiScan() (*WifiScanInfo, error) {
-	scan := func(target string) (*Query, error) {
-		res := &Query{}
-		args := &WifiScanInfo{Refresh: 1}
-		err := dev.Query(&res, target, "get_scaninfo", args)
-		return res, err
-	}
-	info, err := scan("netif")
-	if err != nil {
-		log.Println("can't scan with netif, trying softaponboarding:", err)
-		info, err = scan("smartlive.iot.common.softaponboarding")
+// wifiTargets are the services that handle Wi-Fi setup, in the order to
+// try them.  Plugs use netif and bulbs use softaponboarding.
+var wifiTargets = []string{"netif", "smartlife.iot.common.softaponboarding"}
+
+// wifiQuery sends cmd to each Wi-Fi service in turn until one accepts it.
+// It only moves on when the device rejects the command, since a device
+// that didn't answer won't answer another service either.
+func (dev *BaseDevice) wifiQuery(res interface{}, cmd string, arg interface{}) error {
+	var err error
+	for _, target := range wifiTargets {
+		m := &ModuleBase{target: target, dev: dev.concrete(), base: dev}
+		err = m.Query(res, cmd, arg)
+		var derr *DeviceError
+		if !errors.As(err, &derr) {
+			return err
+		}
+		if Debug {
+			log.Printf("can't %s with %s: %s", cmd, target, err)
+		}
 	}
+	return err
+}
+
+// WifiScan has the device scan for wireless networks it can see.
+func (dev *BaseDevice) WifiScan() (*WifiScanInfo, error) {
+	res := &WifiScanInfo{}
+	err := dev.wifiQuery(res, "get_scaninfo", &WifiScanInfo{Refresh: 1})
 	if err != nil {
-		log.Println("error in WifiScan():", err)
 		return nil, err
 	}
-	data, _ := json.Marshal(info)
-	log.Println("WifiScan() =>", string(data))
-	if info.NetStd != nil {
-		return info.NetStd.WifiScan, nil
-	}
-	if info.NetCommon != nil {
-		return info.NetCommon.WifiScan, nil
-	}
-	return nil, nil
+	return res, nil
 }
 
-func (dev *BaseDevice) WifiJoin(ssid, password string, keytype ...int) error {
-	join := func(target string, payload *WifiAP) (interface{}, error) {
-		var res interface{}
-		err := dev.Query(&res, target, "set_stainfo", payload)
-		return res, err
-	}
+// WifiJoin has the device join a wireless network, using WPA2 unless a key
+// type is given.  The device usually drops off its current network before
+// it answers, so a network error here doesn't mean the join failed.
+func (dev *BaseDevice) WifiJoin(ssid, password string, keytype ...WifiKeyType) error {
 	payload := &WifiAP{
 		SSID: ssid,
 		Password: password,
+		KeyType: WifiKeyWPA2,
 	}
-	if len(keytype) == 0 {
-		payload.KeyType = 3
-	} else {
+	if len(keytype) > 0 {
 		payload.KeyType = keytype[0]
 	}
-	res, err := join("netif", payload)
-	if err != nil {
-		log.Println("Can't join with netif, trying with softaponboarding:", err)
-		res, err = join("smartlife.iot.common.softaponboarding", payload)
-	}
-	if err != nil {
-		log.Println("error in WifiJoin():", err)
-		return err
-	}
-	data, _ := json.Marshal(res)
-	log.Println("WifiJoin() =>", string(data))
-	return nil
+	return dev.wifiQuery(nil, "set_stainfo", payload)
 }
 
 func (dev *BaseDevice) Alias() string {
@@ -489,11 +768,25 @@ func (dev *BaseDevice) DeviceName() string {
 	return sysinfo.DeviceName
 }
 
+// Capabilities returns what the device's model can do, or nil if the
+// model isn't in the model table.
+func (dev *BaseDevice) Capabilities() *ModelInfo {
+	sysinfo := dev.GetSysInfo()
+	if sysinfo == nil {
+		return nil
+	}
+	return LookupModel(sysinfo.Model)
+}
+
 func (dev *BaseDevice) DeviceType() DeviceType {
 	sysinfo := dev.GetSysInfo()
 	if sysinfo == nil {
 		return DeviceTypeUnknown
 	}
+	if model := LookupModel(sysinfo.Model); model != nil {
+		return model.Type
+	}
+	// unknown model; guess from what the device says about itself
 	name := sysinfo.DeviceName
 	if strings.Contains(name, "Dimmer") {
 		return DeviceTypeDimmer
@@ -530,11 +823,19 @@ func (dev *BaseDevice) IsBulb() bool {
 }
 
 func (dev *BaseDevice) IsColor() bool {
-	return false
+	if model := dev.Capabilities(); model != nil {
+		return model.Color
+	}
+	sysinfo := dev.GetSysInfo()
+	return sysinfo != nil && sysinfo.IsColor > 0
 }
 
 func (dev *BaseDevice) IsDimmable() bool {
-	return false
+	if model := dev.Capabilities(); model != nil {
+		return model.Dimmable
+	}
+	sysinfo := dev.GetSysInfo()
+	return sysinfo != nil && sysinfo.IsDimmable > 0
 }
 
 func (dev *BaseDevice) IsDimmer() bool {
@@ -566,9 +867,41 @@ func (dev *BaseDevice) IsStripSocket() bool {
 }
 
 func (dev *BaseDevice) IsVariableColorTemp() bool {
+	if model := dev.Capabilities(); model != nil {
+		return model.ColorTemp != nil
+	}
+	sysinfo := dev.GetSysInfo()
+	return sysinfo != nil && sysinfo.IsVariableColorTemp > 0
+}
+
+// ColorTempRange returns the supported color temperatures in kelvin, or
+// nil if the color temperature can't be changed or isn't known.
+func (dev *BaseDevice) ColorTempRange() *ColorTempRange {
+	if model := dev.Capabilities(); model != nil {
+		return model.ColorTemp
+	}
+	return nil
+}
+
+func (dev *BaseDevice) HasEmeter() bool {
+	if model := dev.Capabilities(); model != nil {
+		return model.Emeter
+	}
+	for _, feature := range dev.Features() {
+		if feature == "ENE" {
+			return true
+		}
+	}
 	return false
 }
 
+func (dev *BaseDevice) HasLED() bool {
+	if model := dev.Capabilities(); model != nil {
+		return model.LED
+	}
+	return dev.DeviceType() != DeviceTypeBulb && dev.DeviceType() != DeviceTypeLightStrip
+}
+
 func (dev *BaseDevice) Location() *LatLon {
 	sysinfo := dev.GetSysInfo()
 	if sysinfo == nil {
@@ -585,6 +918,10 @@ func (dev *BaseDevice) MAC() string {
 	if sysinfo == nil {
 		return ""
 	}
+	if sysinfo.MACAddr == "" {
+		// bulbs report it as mic_mac instead
+		return sysinfo.MicMACAddr
+	}
 	return sysinfo.MACAddr
 }
 
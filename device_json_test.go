@@ -0,0 +1,114 @@
+package kasa
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func jsonTestDevice(t *testing.T, sysinfo string) *BaseDevice {
+	info := &Query{}
+	err := json.Unmarshal([]byte(`{"system":{"get_sysinfo":` + sysinfo + `}}`), info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info.setUpdateTime()
+	return &BaseDevice{Addr: "192.0.2.10", Info: info}
+}
+
+// sameJSON reports whether a and b encode to the same JSON, ignoring key
+// order.
+func sameJSON(t *testing.T, a, b interface{}) bool {
+	var xa, xb interface{}
+	for _, x := range []struct{ src, dst interface{} }{{a, &xa}, {b, &xb}} {
+		data, err := json.Marshal(x.src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = json.Unmarshal(data, x.dst)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !reflect.DeepEqual(xa, xb) {
+		t.Logf("%v != %v", xa, xb)
+		return false
+	}
+	return true
+}
+
+func TestUnmarshalDeviceRoundTrip(t *testing.T) {
+	strip := jsonTestDevice(t, `{"alias":"strip","model":"HS300(US)","deviceId":"STRIP","mac":"AA:BB:CC:DD:EE:03","relay_state":1,"child_num":2,"children":[
+		{"id":"STRIP00","alias":"socket 1","state":1},
+		{"id":"STRIP01","alias":"socket 2","state":0}
+	]}`).AsConcrete().(*SmartStrip)
+	res, err := parseDiscoveryResponse(discoveryReply("AES"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		dev SmartDevice
+		devType DeviceType
+	}{
+		{"plug", jsonTestDevice(t, `{"alias":"lamp","model":"HS100(US)","deviceId":"PLUG","mac":"AA:BB:CC:DD:EE:01","relay_state":1,"dev_state":"normal"}`).AsConcrete(), DeviceTypePlug},
+		{"dimmer", jsonTestDevice(t, `{"alias":"hall","model":"HS220(US)","deviceId":"DIMMER","mac":"AA:BB:CC:DD:EE:02","relay_state":1,"brightness":40}`).AsConcrete(), DeviceTypeDimmer},
+		{"strip", strip, DeviceTypeStrip},
+		{"strip socket", strip.Children()[1], DeviceTypeStripSocket},
+		{"bulb", jsonTestDevice(t, `{"alias":"bulb","model":"KL130(US)","deviceId":"BULB","mic_mac":"AABBCCDDEE04","mic_type":"IOT.SMARTBULB","light_state":{"on_off":1,"brightness":80,"hue":120,"saturation":50,"color_temp":0}}`).AsConcrete(), DeviceTypeBulb},
+		{"light strip", jsonTestDevice(t, `{"alias":"shelf","model":"KL430(US)","deviceId":"LIGHTSTRIP","mic_mac":"AABBCCDDEE05","mic_type":"IOT.SMARTBULB","length":16,"light_state":{"on_off":0}}`).AsConcrete(), DeviceTypeLightStrip},
+		{"encrypted", newEncryptedDevice("192.0.2.11", res), DeviceTypeEncrypted},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if tc.dev.DeviceType() != tc.devType {
+				t.Fatalf("test device is a %s, want %s", tc.dev.DeviceType(), tc.devType)
+			}
+			data, err := json.Marshal(tc.dev)
+			if err != nil {
+				t.Fatal(err)
+			}
+			dev, err := UnmarshalDevice(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if reflect.TypeOf(dev) != reflect.TypeOf(tc.dev) {
+				t.Fatalf("decoded as %T, want %T", dev, tc.dev)
+			}
+			if dev.DeviceType() != tc.devType {
+				t.Errorf("decoded device type %s, want %s", dev.DeviceType(), tc.devType)
+			}
+			if dev.IP() != tc.dev.IP() || dev.DeviceID() != tc.dev.DeviceID() || dev.Alias() != tc.dev.Alias() {
+				t.Errorf("decoded %s %s %q, want %s %s %q", dev.IP(), dev.DeviceID(), dev.Alias(), tc.dev.IP(), tc.dev.DeviceID(), tc.dev.Alias())
+			}
+			if !sameJSON(t, dev.GetSysInfo(), tc.dev.GetSysInfo()) {
+				t.Errorf("sysinfo changed in the round trip")
+			}
+			if !sameJSON(t, dev, tc.dev) {
+				t.Errorf("re-encoded differently")
+			}
+		})
+	}
+}
+
+func TestUnmarshalDeviceErrors(t *testing.T) {
+	plug := jsonTestDevice(t, `{"alias":"lamp","model":"HS100(US)","deviceId":"PLUG"}`).AsConcrete()
+	data, _ := json.Marshal(plug)
+	bulb := &SmartBulb{}
+	if err := json.Unmarshal(data, bulb); err == nil {
+		t.Error("decoded a plug as a bulb")
+	}
+	_, err := UnmarshalDevice([]byte(`{"type":"Toaster","addr":"192.0.2.1"}`))
+	if err == nil || !strings.Contains(err.Error(), "unknown device type") {
+		t.Errorf("unknown type: %v", err)
+	}
+	// no discriminator; the type comes from the sysinfo
+	dev, err := UnmarshalDevice([]byte(`{"addr":"192.0.2.1","info":{"system":{"get_sysinfo":{"model":"HS220(US)"}}}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := dev.(*SmartDimmer); !ok {
+		t.Errorf("untyped dimmer decoded as %T", dev)
+	}
+}
@@ -0,0 +1,48 @@
+package kasa
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+)
+
+// funcTransport answers device queries in-process.
+type funcTransport func(host string, req interface{}, dst interface{}) error
+
+func (f funcTransport) Query(host string, req interface{}, dst interface{}) error {
+	return f(host, req, dst)
+}
+
+func TestRefreshIfStaleBacksOff(t *testing.T) {
+	calls := 0
+	fail := true
+	dev := &BaseDevice{Addr: "192.0.2.1"}
+	dev.SetRetryPolicy(NoRetry)
+	dev.SetTransport(funcTransport(func(host string, req interface{}, dst interface{}) error {
+		calls++
+		if fail {
+			return &netError{errors.New("no route to host")}
+		}
+		return json.Unmarshal([]byte(`{"system":{"get_sysinfo":{"alias":"lamp"}}}`), dst)
+	}))
+	dev.SetMaxAge(50 * time.Millisecond)
+	for i := 0; i < 5; i++ {
+		dev.Alias()
+	}
+	if calls != 1 {
+		t.Fatalf("unreachable device queried %d times within max age, want 1", calls)
+	}
+	time.Sleep(60 * time.Millisecond)
+	fail = false
+	if alias := dev.Alias(); alias != "lamp" {
+		t.Fatalf("alias = %q after max age, want refreshed value", alias)
+	}
+	if calls != 2 {
+		t.Fatalf("%d queries, want 2", calls)
+	}
+	dev.Alias()
+	if calls != 2 {
+		t.Fatalf("fresh sysinfo refreshed again")
+	}
+}
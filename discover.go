@@ -3,129 +3,552 @@ package kasa
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
 	"net"
-	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
 )
 
 var Debug = false
 
-func DiscoverStream(ctx context.Context, retry time.Duration) (chan SmartDevice, error) {
-	query := map[string]interface{}{
-		"system": map[string]interface{}{
-			"get_sysinfo": nil,
-		},
+// DiscoverOptions controls where discovery broadcasts are sent from and
+// to.  The zero value sends to the limited broadcast address
+// 255.255.255.255 from whichever interface the kernel picks.
+type DiscoverOptions struct {
+	// Interface restricts discovery to the IPv4 addresses of the named
+	// network interface, sending to each subnet's directed broadcast
+	// address.
+	Interface string
+	// SourceIP binds discovery to a single local address, sending to the
+	// directed broadcast address of the subnet it belongs to.
+	SourceIP string
+	// BroadcastAddrs overrides the addresses broadcasts are sent to, e.g.
+	// "10.20.3.255".  A port may be given; it defaults to DEFAULT_PORT.
+	BroadcastAddrs []string
+	// AllInterfaces sends from every IPv4 address on every interface that
+	// is up and supports broadcast, except loopback.
+	AllInterfaces bool
+	// LegacyOnly skips the DISCOVERY_PORT probe that newer devices answer,
+	// and only finds devices that speak the legacy protocol.
+	LegacyOnly bool
+}
+
+// discoveryPacket is the probe newer devices answer on DISCOVERY_PORT:
+// a 16-byte header (version 2, probe opcode) with its CRC filled in and
+// no payload.
+var discoveryPacket = []byte{0x02, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x46, 0x3c, 0xb5, 0xd3}
+
+const discoveryHeaderSize = 16
+
+func parseDiscoveryResponse(data []byte) (*DiscoveryResult, error) {
+	if len(data) <= discoveryHeaderSize {
+		return nil, fmt.Errorf("short discovery response (%d bytes)", len(data))
 	}
-	plain, err := json.Marshal(query)
-	if err != nil {
-		return nil, err
+	if Debug {
+		log.Println(string(data[discoveryHeaderSize:]))
 	}
-	sendaddr, err := net.ResolveUDPAddr("udp", "255.255.255.255:9999")
+	res := &DiscoveryResponse{}
+	err := json.Unmarshal(data[discoveryHeaderSize:], res)
 	if err != nil {
 		return nil, err
 	}
-	respaddr, err := net.ResolveUDPAddr("udp", ":0")
+	if res.ErrorCode != 0 || res.Result == nil {
+		return nil, fmt.Errorf("discovery response error %d", res.ErrorCode)
+	}
+	return res.Result, nil
+}
+
+type discoveryProbe struct {
+	local *net.UDPAddr
+	targets []*net.UDPAddr
+}
+
+func resolveBroadcastAddrs(addrs []string) ([]*net.UDPAddr, error) {
+	targets := make([]*net.UDPAddr, len(addrs))
+	for i, addr := range addrs {
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			addr = deviceAddr(addr)
+		}
+		target, err := net.ResolveUDPAddr("udp4", addr)
+		if err != nil {
+			return nil, err
+		}
+		targets[i] = target
+	}
+	return targets, nil
+}
+
+func directedBroadcast(ipnet *net.IPNet) net.IP {
+	ip := ipnet.IP.To4()
+	mask := ipnet.Mask
+	if ip == nil || len(mask) != net.IPv4len {
+		return nil
+	}
+	bcast := make(net.IP, net.IPv4len)
+	for i := range ip {
+		bcast[i] = ip[i] | ^mask[i]
+	}
+	return bcast
+}
+
+func interfaceProbes(iface *net.Interface, override []*net.UDPAddr) ([]*discoveryProbe, error) {
+	addrs, err := iface.Addrs()
 	if err != nil {
 		return nil, err
 	}
-	l, err := net.ListenUDP("udp", respaddr)
+	probes := []*discoveryProbe{}
+	for _, addr := range addrs {
+		ipnet, ok := addr.(*net.IPNet)
+		if !ok || ipnet.IP.To4() == nil {
+			continue
+		}
+		probe := &discoveryProbe{
+			local: &net.UDPAddr{IP: ipnet.IP.To4()},
+			targets: override,
+		}
+		if probe.targets == nil {
+			probe.targets = []*net.UDPAddr{{IP: directedBroadcast(ipnet), Port: DEFAULT_PORT}}
+		}
+		probes = append(probes, probe)
+	}
+	return probes, nil
+}
+
+func sourceIPProbe(source net.IP, override []*net.UDPAddr) (*discoveryProbe, error) {
+	probe := &discoveryProbe{
+		local: &net.UDPAddr{IP: source},
+		targets: override,
+	}
+	if probe.targets != nil {
+		return probe, nil
+	}
+	addrs, err := net.InterfaceAddrs()
 	if err != nil {
-		log.Println(err)
 		return nil, err
 	}
-	log.Println("listening on", l.LocalAddr().String())
-	maxSize := 8192
-	l.SetReadBuffer(maxSize)
-	ch := make(chan SmartDevice, 10)
-	quit := &atomic.Bool{}
-	go func() {
-		defer close(ch)
-		for {
-			if quit.Load() {
-				return
+	for _, addr := range addrs {
+		ipnet, ok := addr.(*net.IPNet)
+		if ok && ipnet.IP.Equal(source) {
+			bcast := directedBroadcast(ipnet)
+			if bcast != nil {
+				probe.targets = []*net.UDPAddr{{IP: bcast, Port: DEFAULT_PORT}}
+				return probe, nil
+			}
+		}
+	}
+	probe.targets = []*net.UDPAddr{{IP: net.IPv4bcast, Port: DEFAULT_PORT}}
+	return probe, nil
+}
+
+func (opts *DiscoverOptions) probes() ([]*discoveryProbe, error) {
+	if opts == nil {
+		opts = &DiscoverOptions{}
+	}
+	var override []*net.UDPAddr
+	if len(opts.BroadcastAddrs) > 0 {
+		var err error
+		override, err = resolveBroadcastAddrs(opts.BroadcastAddrs)
+		if err != nil {
+			return nil, err
+		}
+	}
+	switch {
+	case opts.SourceIP != "":
+		source := net.ParseIP(opts.SourceIP).To4()
+		if source == nil {
+			return nil, fmt.Errorf("invalid source IP '%s'", opts.SourceIP)
+		}
+		probe, err := sourceIPProbe(source, override)
+		if err != nil {
+			return nil, err
+		}
+		return []*discoveryProbe{probe}, nil
+	case opts.Interface != "":
+		iface, err := net.InterfaceByName(opts.Interface)
+		if err != nil {
+			return nil, err
+		}
+		probes, err := interfaceProbes(iface, override)
+		if err != nil {
+			return nil, err
+		}
+		if len(probes) == 0 {
+			return nil, fmt.Errorf("interface %s has no IPv4 addresses", opts.Interface)
+		}
+		return probes, nil
+	case opts.AllInterfaces:
+		ifaces, err := net.Interfaces()
+		if err != nil {
+			return nil, err
+		}
+		probes := []*discoveryProbe{}
+		for i := range ifaces {
+			iface := &ifaces[i]
+			if iface.Flags & net.FlagUp == 0 || iface.Flags & net.FlagBroadcast == 0 || iface.Flags & net.FlagLoopback != 0 {
+				continue
 			}
-			b := make([]byte, maxSize)
-			l.SetReadDeadline(time.Now().Add(time.Second))
-			n, src, err := l.ReadFromUDP(b)
+			ifprobes, err := interfaceProbes(iface, override)
 			if err != nil {
-				if !strings.Contains(err.Error(), "i/o timeout") {
-					log.Println(err)
-				}
+				log.Printf("can't list addresses for %s: %s", iface.Name, err)
 				continue
 			}
-			if quit.Load() {
+			probes = append(probes, ifprobes...)
+		}
+		if len(probes) == 0 {
+			return nil, fmt.Errorf("no usable network interfaces")
+		}
+		return probes, nil
+	}
+	if override == nil {
+		override = []*net.UDPAddr{{IP: net.IPv4bcast, Port: DEFAULT_PORT}}
+	}
+	return []*discoveryProbe{{local: &net.UDPAddr{}, targets: override}}, nil
+}
+
+const DEFAULT_SCAN_BUFFER = 32
+
+var ErrScannerClosed = errors.New("scanner closed")
+
+// Scanner broadcasts discovery probes at a fixed interval and reports the
+// devices that answer.  Devices are delivered on a buffered channel; if the
+// consumer falls behind, replies are dropped rather than stalling the
+// scanner, and the device will be reported again after the next probe.
+type Scanner struct {
+	Retry time.Duration
+	Options *DiscoverOptions
+	// Buffer is the capacity of the device channel.  Zero means
+	// DEFAULT_SCAN_BUFFER.
+	Buffer int
+	// Rounds, if set, is signaled each time a round of probes ends, after
+	// the devices that answered it have been queued on the device channel.
+	// A signal is skipped if the last one hasn't been received yet.
+	Rounds chan struct{}
+	lock sync.Mutex
+	run *scanRun
+	closed bool
+	dropped atomic.Int64
+}
+
+type scanRun struct {
+	conns []*net.UDPConn
+	probes []*discoveryProbe
+	ch chan SmartDevice
+	done chan struct{}
+	stopOnce sync.Once
+	readers sync.WaitGroup
+	prober sync.WaitGroup
+	// addresses that answered the legacy probe, so they aren't reported a
+	// second time as encrypted devices
+	legacy sync.Map
+	// encrypted devices heard from this round, held back until the round
+	// ends in case the same device also answers the legacy probe
+	pendingLock sync.Mutex
+	pending map[string]*DiscoveryResult
+	// closed is set, under pendingLock, once ch is closed
+	closed bool
+}
+
+func NewScanner(retry time.Duration, opts *DiscoverOptions) *Scanner {
+	return &Scanner{Retry: retry, Options: opts}
+}
+
+// Dropped returns the number of replies discarded because the consumer
+// wasn't keeping up.
+func (s *Scanner) Dropped() int64 {
+	return s.dropped.Load()
+}
+
+// Start opens the discovery sockets and begins probing.  The returned
+// channel is closed when the scanner is stopped, either explicitly or
+// because ctx is done.  A stopped scanner may be started again; a closed
+// one may not.
+func (s *Scanner) Start(ctx context.Context) (chan SmartDevice, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.closed {
+		return nil, ErrScannerClosed
+	}
+	if s.run != nil {
+		return s.run.ch, nil
+	}
+	probes, err := s.Options.probes()
+	if err != nil {
+		return nil, err
+	}
+	run := &scanRun{
+		probes: probes,
+		conns: make([]*net.UDPConn, len(probes)),
+		done: make(chan struct{}),
+		pending: map[string]*DiscoveryResult{},
+	}
+	for i, probe := range probes {
+		l, err := net.ListenUDP("udp4", probe.local)
+		if err != nil {
+			for _, conn := range run.conns[:i] {
+				conn.Close()
+			}
+			return nil, err
+		}
+		if Debug {
+			log.Println("listening on", l.LocalAddr().String())
+		}
+		l.SetReadBuffer(DEFAULT_UDP_RESPONSE_SIZE)
+		run.conns[i] = l
+	}
+	buffer := s.Buffer
+	if buffer <= 0 {
+		buffer = DEFAULT_SCAN_BUFFER
+	}
+	run.ch = make(chan SmartDevice, buffer)
+	s.run = run
+	for _, l := range run.conns {
+		run.readers.Add(1)
+		go s.read(run, l)
+	}
+	run.prober.Add(1)
+	go s.probe(ctx, run)
+	return run.ch, nil
+}
+
+// Stop stops probing, closes the sockets and closes the device channel.
+// It waits for the scanner's goroutines to exit.
+func (s *Scanner) Stop() {
+	s.lock.Lock()
+	run := s.run
+	s.lock.Unlock()
+	if run != nil {
+		s.finish(run)
+		// the prober finishes the run itself when ctx is done, so it
+		// can't be waited for inside stop
+		run.prober.Wait()
+	}
+}
+
+func (s *Scanner) finish(run *scanRun) {
+	s.lock.Lock()
+	if s.run == run {
+		s.run = nil
+	}
+	s.lock.Unlock()
+	s.stop(run)
+}
+
+// Close stops the scanner for good.
+func (s *Scanner) Close() error {
+	s.lock.Lock()
+	s.closed = true
+	s.lock.Unlock()
+	s.Stop()
+	return nil
+}
+
+func (s *Scanner) stop(run *scanRun) {
+	run.stopOnce.Do(func() {
+		close(run.done)
+		for _, l := range run.conns {
+			l.Close()
+		}
+		run.readers.Wait()
+		run.pendingLock.Lock()
+		defer run.pendingLock.Unlock()
+		s.flushLocked(run)
+		run.closed = true
+		close(run.ch)
+	})
+}
+
+// emit hands a device to the consumer, dropping it if the consumer isn't
+// keeping up.
+func (s *Scanner) emit(run *scanRun, dev SmartDevice) {
+	select {
+	case run.ch <- dev:
+	default:
+		s.dropped.Add(1)
+		if Debug {
+			log.Println("consumer not keeping up, dropped reply from", dev.IP())
+		}
+	}
+}
+
+// flush ends a round, reporting the encrypted devices that didn't also
+// answer the legacy probe.
+func (s *Scanner) flush(run *scanRun) {
+	run.pendingLock.Lock()
+	defer run.pendingLock.Unlock()
+	if !run.closed {
+		s.flushLocked(run)
+	}
+}
+
+func (s *Scanner) flushLocked(run *scanRun) {
+	pending := run.pending
+	run.pending = map[string]*DiscoveryResult{}
+	for ip, res := range pending {
+		if _, seen := run.legacy.Load(ip); seen {
+			continue
+		}
+		s.emit(run, newEncryptedDevice(ip, res))
+	}
+}
+
+func (s *Scanner) read(run *scanRun, l *net.UDPConn) {
+	defer run.readers.Done()
+	b := make([]byte, DEFAULT_UDP_RESPONSE_SIZE)
+	for {
+		n, src, err := l.ReadFromUDP(b)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
 				return
 			}
-			plain := decrypt(b[:n])
-			if Debug {
-				log.Println(string(plain))
+			log.Println(err)
+			continue
+		}
+		dev := run.parse(b[:n], src)
+		if dev == nil {
+			continue
+		}
+		s.emit(run, dev)
+	}
+}
+
+func (run *scanRun) parse(data []byte, src *net.UDPAddr) SmartDevice {
+	if src.Port == DISCOVERY_PORT {
+		res, err := parseDiscoveryResponse(data)
+		if err != nil {
+			log.Println(err)
+			return nil
+		}
+		if res.RequiredTransport() == TransportXOR {
+			// the legacy probe reaches it
+			return nil
+		}
+		if _, seen := run.legacy.Load(src.IP.String()); seen {
+			return nil
+		}
+		run.pendingLock.Lock()
+		run.pending[src.IP.String()] = res
+		run.pendingLock.Unlock()
+		return nil
+	}
+	run.legacy.Store(src.IP.String(), true)
+	plain := decrypt(data)
+	if Debug {
+		log.Println(string(plain))
+	}
+	info := &Query{}
+	err := json.Unmarshal(plain, &info)
+	if err != nil {
+		log.Println(err)
+		return nil
+	}
+	info.setUpdateTime()
+	dev := &BaseDevice{Addr: src.IP.String(), Info: info}
+	return dev.AsConcrete()
+}
+
+var discoveryQuery = encrypt([]byte(`{"system":{"get_sysinfo":null}}`))
+
+func (s *Scanner) send(run *scanRun) error {
+	legacyOnly := s.Options != nil && s.Options.LegacyOnly
+	sent := 0
+	for i, l := range run.conns {
+		for _, target := range run.probes[i].targets {
+			_, err := l.WriteTo(discoveryQuery, target)
+			if errors.Is(err, net.ErrClosed) {
+				// stopped while sending
+				return nil
 			}
-			info := &Query{}
-			err = json.Unmarshal(plain, &info)
 			if err != nil {
-				log.Println(err)
+				log.Printf("error querying %s from %s: %s", target, l.LocalAddr(), err)
 				continue
 			}
-			dev := &BaseDevice{Addr: src.IP.String(), Info: info}
-			dev.setUpdateTime()
-			ch <- dev.AsConcrete()
-		}
-	}()
-
-	go func() {
-		defer quit.Store(true)
-		requery := func() error {
-			_, err = l.WriteTo(encrypt(plain), sendaddr)
+			sent += 1
+			if legacyOnly {
+				continue
+			}
+			target2 := &net.UDPAddr{IP: target.IP, Port: DISCOVERY_PORT}
+			_, err = l.WriteTo(discoveryPacket, target2)
 			if err != nil {
-				return err
+				log.Printf("error querying %s from %s: %s", target2, l.LocalAddr(), err)
 			}
-			return nil
 		}
-		err := requery()
-		if err != nil {
-			log.Println("error querying:", err)
-			return
+	}
+	if sent == 0 {
+		return fmt.Errorf("no discovery broadcasts could be sent")
+	}
+	return nil
+}
+
+func (s *Scanner) probe(ctx context.Context, run *scanRun) {
+	defer run.prober.Done()
+	defer s.finish(run)
+	err := s.send(run)
+	if err != nil {
+		log.Println("error querying:", err)
+		return
+	}
+	if s.Retry <= 0 {
+		select {
+		case <-ctx.Done():
+		case <-run.done:
 		}
-		ticker := time.NewTicker(retry)
-		for {
-			select {
-			case <-ctx.Done():
-				return
-			case <-ticker.C:
-				err = requery()
-				if err != nil {
-					log.Println("error querying:", err)
-					return
+		return
+	}
+	ticker := time.NewTicker(s.Retry)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-run.done:
+			return
+		case <-ticker.C:
+			s.flush(run)
+			if s.Rounds != nil {
+				select {
+				case s.Rounds <- struct{}{}:
+				default:
 				}
 			}
+			err = s.send(run)
+			if err != nil {
+				log.Println("error querying:", err)
+				return
+			}
 		}
-	}()
-	return ch, nil
+	}
+}
+
+func DiscoverStream(ctx context.Context, retry time.Duration) (chan SmartDevice, error) {
+	return DiscoverStreamWithOptions(ctx, retry, nil)
+}
+
+// DiscoverStreamWithOptions starts a Scanner that runs until ctx is done.
+func DiscoverStreamWithOptions(ctx context.Context, retry time.Duration, opts *DiscoverOptions) (chan SmartDevice, error) {
+	return NewScanner(retry, opts).Start(ctx)
 }
 
 func Discover(timeout time.Duration) ([]SmartDevice, error) {
-	ctx, _ := context.WithTimeout(context.Background(), timeout)
-	quitch := make(chan bool, 2)
-	ch, err := DiscoverStream(ctx, timeout)
+	return DiscoverWithOptions(timeout, nil)
+}
+
+func DiscoverWithOptions(timeout time.Duration, opts *DiscoverOptions) ([]SmartDevice, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	scanner := NewScanner(0, opts)
+	// Discover sends a single probe, so make room for every reply to it
+	scanner.Buffer = 1024
+	ch, err := scanner.Start(ctx)
 	if err != nil {
 		return nil, err
 	}
+	defer scanner.Close()
 	devices := []SmartDevice{}
-	timer := time.NewTimer(timeout)
-	for {
-		select {
-		case dev, ok := <-ch:
-			if !ok {
-				quitch <- true
-				return devices, nil
-			}
-			devices = append(devices, dev)
-		case <-timer.C:
-			quitch <- true
-			return devices, nil
-		}
+	for dev := range ch {
+		devices = append(devices, dev)
 	}
 	return devices, nil
 }
@@ -0,0 +1,98 @@
+package kasa
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+func discoveryReply(encryptType string) []byte {
+	body := `{"result":{"device_type":"IOT.SMARTPLUGSWITCH","device_model":"KP125M(US)","mac":"AA-BB-CC-DD-EE-FF","device_id":"ID","mgt_encrypt_schm":{"encrypt_type":"` + encryptType + `"}},"error_code":0}`
+	return append(make([]byte, discoveryHeaderSize), body...)
+}
+
+var legacyReply = encrypt([]byte(`{"system":{"get_sysinfo":{"alias":"plug","mic_type":"IOT.SMARTPLUGSWITCH","deviceId":"ID"}}}`))
+
+func TestScannerDedupe(t *testing.T) {
+	legacySrc := &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: DEFAULT_PORT}
+	encryptedSrc := &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: DISCOVERY_PORT}
+	tests := []struct {
+		name string
+		replies []*net.UDPAddr
+		encryptType string
+		want []DeviceType
+	}{
+		{"legacy first", []*net.UDPAddr{legacySrc, encryptedSrc}, "KLAP", []DeviceType{DeviceTypePlug}},
+		{"encrypted first", []*net.UDPAddr{encryptedSrc, legacySrc}, "KLAP", []DeviceType{DeviceTypePlug}},
+		{"encrypted only", []*net.UDPAddr{encryptedSrc}, "KLAP", []DeviceType{DeviceTypeEncrypted}},
+		{"xor on 20002 only", []*net.UDPAddr{encryptedSrc}, "XOR", nil},
+	}
+	for _, tc := range tests {
+		s := &Scanner{}
+		run := &scanRun{
+			ch: make(chan SmartDevice, 10),
+			done: make(chan struct{}),
+			pending: map[string]*DiscoveryResult{},
+		}
+		for _, src := range tc.replies {
+			data := legacyReply
+			if src.Port == DISCOVERY_PORT {
+				data = discoveryReply(tc.encryptType)
+			}
+			if dev := run.parse(data, src); dev != nil {
+				s.emit(run, dev)
+			}
+		}
+		s.stop(run)
+		got := []DeviceType{}
+		for dev := range run.ch {
+			got = append(got, dev.DeviceType())
+		}
+		if len(got) != len(tc.want) {
+			t.Errorf("%s: got %v, want %v", tc.name, got, tc.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tc.want[i] {
+				t.Errorf("%s: got %v, want %v", tc.name, got, tc.want)
+			}
+		}
+	}
+}
+
+func TestScannerStopWaitsForProber(t *testing.T) {
+	target, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer target.Close()
+	scanner := NewScanner(5 * time.Millisecond, &DiscoverOptions{BroadcastAddrs: []string{target.LocalAddr().String()}, LegacyOnly: true})
+	scanner.Rounds = make(chan struct{}, 1)
+	ch, err := scanner.Start(context.Background())
+	if err != nil {
+		t.Skip("can't open discovery socket:", err)
+	}
+	for i := 0; i < 3; i++ {
+		select {
+		case <-scanner.Rounds:
+		case <-time.After(time.Second):
+			t.Fatal("no probe rounds")
+		}
+	}
+	scanner.Stop()
+	if _, ok := <-ch; ok {
+		t.Fatal("device channel still open after Stop")
+	}
+	// anything signaled before Stop returned is fair; nothing after
+	select {
+	case <-scanner.Rounds:
+	default:
+	}
+	time.Sleep(30 * time.Millisecond)
+	select {
+	case <-scanner.Rounds:
+		t.Error("scanner probed after Stop returned")
+	default:
+	}
+}
@@ -0,0 +1,134 @@
+package kasa
+
+import (
+	"context"
+	"errors"
+	"path"
+	"strings"
+	"time"
+)
+
+var ErrDeviceNotFound = errors.New("device not found")
+
+const DEFAULT_FIND_RETRY = time.Second
+
+// DeviceMatcher returns the devices that match, which may be children of
+// the device it was given (e.g. strip sockets), or nil.
+type DeviceMatcher func(SmartDevice) []SmartDevice
+
+// matchSelfOrChildren matches dev itself if it passes test, and otherwise
+// every strip socket that does.
+func matchSelfOrChildren(test func(SmartDevice) bool) DeviceMatcher {
+	return func(dev SmartDevice) []SmartDevice {
+		if test(dev) {
+			return []SmartDevice{dev}
+		}
+		strip, ok := dev.(*SmartStrip)
+		if !ok {
+			return nil
+		}
+		var found []SmartDevice
+		for _, child := range strip.Children() {
+			if test(child) {
+				found = append(found, child)
+			}
+		}
+		return found
+	}
+}
+
+func MatchAlias(alias string) DeviceMatcher {
+	return matchSelfOrChildren(func(dev SmartDevice) bool {
+		return dev.Alias() == alias
+	})
+}
+
+// MatchAliasGlob matches aliases against a shell pattern such as
+// "Porch*", ignoring case.
+func MatchAliasGlob(pattern string) DeviceMatcher {
+	pattern = strings.ToLower(pattern)
+	return matchSelfOrChildren(func(dev SmartDevice) bool {
+		ok, _ := path.Match(pattern, strings.ToLower(dev.Alias()))
+		return ok
+	})
+}
+
+// MatchAliasPrefix matches aliases that start with prefix, ignoring case.
+func MatchAliasPrefix(prefix string) DeviceMatcher {
+	prefix = strings.ToLower(prefix)
+	return matchSelfOrChildren(func(dev SmartDevice) bool {
+		return strings.HasPrefix(strings.ToLower(dev.Alias()), prefix)
+	})
+}
+
+func normalizeMAC(mac string) string {
+	return strings.ToUpper(strings.NewReplacer(":", "", "-", "", ".", "").Replace(mac))
+}
+
+// MatchMAC matches MAC addresses regardless of case or separators.
+func MatchMAC(mac string) DeviceMatcher {
+	mac = normalizeMAC(mac)
+	return func(dev SmartDevice) []SmartDevice {
+		if normalizeMAC(dev.MAC()) == mac {
+			return []SmartDevice{dev}
+		}
+		return nil
+	}
+}
+
+func MatchDeviceID(id string) DeviceMatcher {
+	return matchSelfOrChildren(func(dev SmartDevice) bool {
+		return dev.DeviceID() == id
+	})
+}
+
+// FilterDevices returns every match among devices, in order, including
+// all the matching sockets of a strip.
+func FilterDevices(devices []SmartDevice, match DeviceMatcher) []SmartDevice {
+	found := []SmartDevice{}
+	seen := map[string]bool{}
+	for _, dev := range devices {
+		for _, xdev := range match(dev) {
+			key := deviceKey(xdev)
+			if key != "" && seen[key] {
+				continue
+			}
+			seen[key] = true
+			found = append(found, xdev)
+		}
+	}
+	return found
+}
+
+// FindDevice runs discovery until a device matches and returns it right
+// away.  It gives up with ErrDeviceNotFound when ctx is done.
+func FindDevice(ctx context.Context, match DeviceMatcher, opts *DiscoverOptions) (SmartDevice, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	ch, err := DiscoverStreamWithOptions(ctx, DEFAULT_FIND_RETRY, opts)
+	if err != nil {
+		return nil, err
+	}
+	for dev := range ch {
+		if found := match(dev); len(found) > 0 {
+			return found[0], nil
+		}
+	}
+	return nil, ErrDeviceNotFound
+}
+
+func FindByAlias(ctx context.Context, alias string) (SmartDevice, error) {
+	return FindDevice(ctx, MatchAlias(alias), nil)
+}
+
+func FindByAliasGlob(ctx context.Context, pattern string) (SmartDevice, error) {
+	return FindDevice(ctx, MatchAliasGlob(pattern), nil)
+}
+
+func FindByMAC(ctx context.Context, mac string) (SmartDevice, error) {
+	return FindDevice(ctx, MatchMAC(mac), nil)
+}
+
+func FindByDeviceID(ctx context.Context, id string) (SmartDevice, error) {
+	return FindDevice(ctx, MatchDeviceID(id), nil)
+}
@@ -0,0 +1,68 @@
+package kasa
+
+import (
+	"fmt"
+	"testing"
+)
+
+func findTestDevices() []SmartDevice {
+	strip := &SysInfo{
+		DeviceID: "STRIP",
+		Alias: "Power strip",
+		Model: "HS300(US)",
+		MicType: "IOT.SMARTPLUGSWITCH",
+		MACAddr: "AA:BB:CC:DD:EE:01",
+		Children: []*SysInfo{
+			{ID: "00", Alias: "Kitchen 1"},
+			{ID: "01", Alias: "Kitchen 2"},
+			{ID: "02", Alias: "Office"},
+		},
+	}
+	plug := &SysInfo{
+		DeviceID: "PLUG",
+		Alias: "Kitchen lamp",
+		Model: "HS103(US)",
+		MicType: "IOT.SMARTPLUGSWITCH",
+		MACAddr: "AA:BB:CC:DD:EE:02",
+	}
+	devices := []SmartDevice{}
+	for i, sysinfo := range []*SysInfo{strip, plug} {
+		dev := &BaseDevice{Addr: fmt.Sprintf("192.0.2.%d", i + 1), Info: &Query{System: &SysInfoResponse{SysInfo: sysinfo}}}
+		devices = append(devices, dev.AsConcrete())
+	}
+	return devices
+}
+
+func TestFilterDevices(t *testing.T) {
+	devices := findTestDevices()
+	tests := []struct {
+		name string
+		match DeviceMatcher
+		want []string
+	}{
+		{"prefix matches every socket", MatchAliasPrefix("kitchen"), []string{"Kitchen 1", "Kitchen 2", "Kitchen lamp"}},
+		{"glob", MatchAliasGlob("kitchen ?"), []string{"Kitchen 1", "Kitchen 2"}},
+		{"exact alias", MatchAlias("Office"), []string{"Office"}},
+		{"strip itself", MatchAlias("Power strip"), []string{"Power strip"}},
+		{"device id", MatchDeviceID("STRIP01"), []string{"Kitchen 2"}},
+		{"mac", MatchMAC("aa-bb-cc-dd-ee-02"), []string{"Kitchen lamp"}},
+		{"no match", MatchAlias("Garage"), []string{}},
+	}
+	for _, tc := range tests {
+		found := FilterDevices(devices, tc.match)
+		got := make([]string, len(found))
+		for i, dev := range found {
+			got[i] = dev.Alias()
+		}
+		if len(got) != len(tc.want) {
+			t.Errorf("%s: got %v, want %v", tc.name, got, tc.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tc.want[i] {
+				t.Errorf("%s: got %v, want %v", tc.name, got, tc.want)
+				break
+			}
+		}
+	}
+}
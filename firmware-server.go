@@ -0,0 +1,102 @@
+package kasa
+
+import (
+	"bytes"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// FirmwareServer serves a single firmware image over HTTP, so devices can
+// be updated from the local network, or from a test, without the cloud.
+type FirmwareServer struct {
+	Name string
+	image []byte
+	listener net.Listener
+	server *http.Server
+	lock sync.Mutex
+	downloads int
+}
+
+// NewFirmwareServer starts serving image at /name on addr, such as ":0"
+// for any free port.
+func NewFirmwareServer(addr, name string, image []byte) (*FirmwareServer, error) {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	srv := &FirmwareServer{
+		Name: name,
+		image: image,
+		listener: l,
+	}
+	srv.server = &http.Server{Handler: srv}
+	go srv.server.Serve(l)
+	return srv, nil
+}
+
+// ServeFirmwareFile is like NewFirmwareServer, with the image read from
+// fn and served under its base name.
+func ServeFirmwareFile(addr, fn string) (*FirmwareServer, error) {
+	image, err := os.ReadFile(fn)
+	if err != nil {
+		return nil, err
+	}
+	return NewFirmwareServer(addr, path.Base(fn), image)
+}
+
+func (srv *FirmwareServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" + srv.Name {
+		http.NotFound(w, r)
+		return
+	}
+	srv.lock.Lock()
+	srv.downloads++
+	srv.lock.Unlock()
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Length", strconv.Itoa(len(srv.image)))
+	http.ServeContent(w, r, srv.Name, time.Time{}, bytes.NewReader(srv.image))
+}
+
+// Addr returns the address the server is listening on.
+func (srv *FirmwareServer) Addr() net.Addr {
+	return srv.listener.Addr()
+}
+
+// URL returns the image's URL using the given host, which must be an
+// address of this machine reachable from the device.
+func (srv *FirmwareServer) URL(host string) string {
+	_, port, _ := net.SplitHostPort(srv.listener.Addr().String())
+	return "http://" + net.JoinHostPort(host, port) + "/" + srv.Name
+}
+
+// URLFor returns the image's URL using the local address that routes to
+// dev.
+func (srv *FirmwareServer) URLFor(dev SmartDevice) (string, error) {
+	// connecting a UDP socket sends nothing, but picks the local address
+	conn, err := net.Dial("udp", deviceAddr(dev.IP()))
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	host, _, err := net.SplitHostPort(conn.LocalAddr().String())
+	if err != nil {
+		return "", err
+	}
+	return srv.URL(host), nil
+}
+
+// Downloads returns the number of times the image has been requested.
+func (srv *FirmwareServer) Downloads() int {
+	srv.lock.Lock()
+	defer srv.lock.Unlock()
+	return srv.downloads
+}
+
+func (srv *FirmwareServer) Close() error {
+	return srv.server.Close()
+}
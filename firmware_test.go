@@ -0,0 +1,336 @@
+package kasa
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeFirmwareDevice speaks the device protocol over TCP and implements
+// just enough of the system service to be updated.  The image is fetched
+// from whatever URL download_firmware is given.
+type fakeFirmwareDevice struct {
+	listener net.Listener
+	lock sync.Mutex
+	version string
+	newVersion string
+	image []byte
+	status int
+	polls int
+	rebooted bool
+	// get_sysinfo calls still answered with the old version after the
+	// image has downloaded, as if the device hadn't rebooted yet
+	stalePolls int
+	// get_sysinfo calls dropped after that, while the device reboots
+	downPolls int
+	// strip answers as an HS300 with one socket
+	strip bool
+}
+
+func newFakeFirmwareDevice(t *testing.T, dev *fakeFirmwareDevice) *fakeFirmwareDevice {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dev.listener = l
+	dev.version = "1.0.0 Build 200101 Rel.100000"
+	if dev.newVersion == "" {
+		dev.newVersion = "1.1.0 Build 240101 Rel.120000"
+	}
+	go dev.serve()
+	t.Cleanup(func() { l.Close() })
+	return dev
+}
+
+// addrTransport sends every query to addr over TCP, whatever the host.
+type addrTransport string
+
+func (addr addrTransport) Query(host string, req interface{}, dst interface{}) error {
+	payload, err := marshalRequest(req)
+	if err != nil {
+		return err
+	}
+	conn, err := net.DialTimeout("tcp", string(addr), time.Second)
+	if err != nil {
+		return &netError{err}
+	}
+	defer conn.Close()
+	err = writeMessage(conn, payload, time.Second)
+	if err != nil {
+		return err
+	}
+	plain, err := readMessage(conn, time.Second)
+	if err != nil {
+		return err
+	}
+	return unmarshalResponse(plain, dst)
+}
+
+func (dev *fakeFirmwareDevice) Addr() string {
+	return dev.listener.Addr().String()
+}
+
+func (dev *fakeFirmwareDevice) Version() string {
+	dev.lock.Lock()
+	defer dev.lock.Unlock()
+	return dev.version
+}
+
+func (dev *fakeFirmwareDevice) serve() {
+	for {
+		conn, err := dev.listener.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer conn.Close()
+			for {
+				req, err := readMessage(conn, time.Second)
+				if err != nil {
+					return
+				}
+				res := dev.handle(req)
+				if res == nil {
+					// rebooting
+					return
+				}
+				err = writeMessage(conn, res, time.Second)
+				if err != nil {
+					return
+				}
+			}
+		}()
+	}
+}
+
+func (dev *fakeFirmwareDevice) handle(req []byte) []byte {
+	xreq := map[string]map[string]json.RawMessage{}
+	json.Unmarshal(req, &xreq)
+	res := map[string]interface{}{"err_code": -1, "err_msg": "module not support"}
+	for cmd, arg := range xreq["system"] {
+		xres, up := dev.command(cmd, arg)
+		if !up {
+			return nil
+		}
+		if xres != nil {
+			res = map[string]interface{}{cmd: xres}
+		}
+	}
+	data, _ := json.Marshal(map[string]interface{}{"system": res})
+	return data
+}
+
+// command runs cmd, reporting false if the device is down and doesn't
+// answer.
+func (dev *fakeFirmwareDevice) command(cmd string, arg json.RawMessage) (map[string]interface{}, bool) {
+	switch cmd {
+	case "get_sysinfo":
+		dev.lock.Lock()
+		defer dev.lock.Unlock()
+		if dev.image != nil && !dev.rebooted {
+			if dev.stalePolls > 0 {
+				dev.stalePolls--
+			} else if dev.downPolls > 0 {
+				dev.downPolls--
+				return nil, false
+			} else {
+				dev.version = dev.newVersion
+				dev.rebooted = true
+			}
+		}
+		sysinfo := map[string]interface{}{
+			"alias": "fake plug",
+			"deviceId": "FAKE01",
+			"model": "HS100(US)",
+			"sw_ver": dev.version,
+			"relay_state": 1,
+			"err_code": 0,
+		}
+		if dev.strip {
+			sysinfo["model"] = "HS300(US)"
+			sysinfo["children"] = []map[string]interface{}{{"id": "00", "alias": "socket", "state": 1}}
+		}
+		return sysinfo, true
+	case "download_firmware":
+		xarg := &struct{
+			URL string `json:"url"`
+		}{}
+		json.Unmarshal(arg, xarg)
+		image, err := fetchImage(xarg.URL)
+		dev.lock.Lock()
+		defer dev.lock.Unlock()
+		dev.polls = 0
+		if err != nil {
+			dev.status = -3
+			dev.image = nil
+		} else {
+			dev.status = 1
+			dev.image = image
+		}
+		return map[string]interface{}{"err_code": 0}, true
+	case "get_download_state":
+		dev.lock.Lock()
+		defer dev.lock.Unlock()
+		ratio := 0
+		if dev.status > 0 {
+			dev.polls++
+			ratio = 50 * dev.polls
+			if ratio > 100 {
+				ratio = 100
+			}
+		}
+		return map[string]interface{}{"status": dev.status, "ratio": ratio, "flash_time": 0, "reboot_time": 0, "err_code": 0}, true
+	case "flash_firmware":
+		dev.lock.Lock()
+		defer dev.lock.Unlock()
+		if dev.image == nil || dev.polls < 2 {
+			return map[string]interface{}{"err_code": -4, "err_msg": "no image"}, true
+		}
+		return map[string]interface{}{"err_code": 0}, true
+	}
+	return nil, true
+}
+
+func fetchImage(url string) ([]byte, error) {
+	res, err := http.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, errors.New(res.Status)
+	}
+	return io.ReadAll(res.Body)
+}
+
+func TestFirmwareUpdate(t *testing.T) {
+	image := bytes.Repeat([]byte("firmware"), 1024)
+	srv, err := NewFirmwareServer("127.0.0.1:0", "hs100.bin", image)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+	tests := []struct {
+		name string
+		path string
+		fake *fakeFirmwareDevice
+		socket bool
+		timeout time.Duration
+		stages []FirmwareStage
+		ratios []int
+		err func(err error) bool
+		updated bool
+	}{
+		{
+			name: "update",
+			path: "hs100.bin",
+			fake: &fakeFirmwareDevice{stalePolls: 2},
+			timeout: 5 * time.Second,
+			stages: []FirmwareStage{FirmwareDownloading, FirmwareDownloading, FirmwareFlashing, FirmwareRebooting, FirmwareDone},
+			ratios: []int{50, 100, 100, 100, 100},
+			updated: true,
+		},
+		{
+			name: "download fails",
+			path: "missing.bin",
+			fake: &fakeFirmwareDevice{},
+			timeout: 5 * time.Second,
+			stages: []FirmwareStage{FirmwareFailed},
+			ratios: []int{0},
+			err: func(err error) bool {
+				var derr *DeviceError
+				return errors.As(err, &derr) && derr.Code == -3
+			},
+		},
+		{
+			name: "never reboots",
+			path: "hs100.bin",
+			fake: &fakeFirmwareDevice{stalePolls: 1000},
+			timeout: 300 * time.Millisecond,
+			stages: []FirmwareStage{FirmwareDownloading, FirmwareDownloading, FirmwareFlashing, FirmwareRebooting, FirmwareFailed},
+			ratios: []int{50, 100, 100, 100, 0},
+			err: func(err error) bool {
+				return errors.Is(err, context.DeadlineExceeded)
+			},
+		},
+		{
+			name: "same version",
+			path: "hs100.bin",
+			fake: &fakeFirmwareDevice{newVersion: "1.0.0 Build 200101 Rel.100000", stalePolls: 1, downPolls: 2},
+			timeout: 5 * time.Second,
+			stages: []FirmwareStage{FirmwareDownloading, FirmwareDownloading, FirmwareFlashing, FirmwareRebooting, FirmwareDone},
+			ratios: []int{50, 100, 100, 100, 100},
+		},
+		{
+			name: "strip socket",
+			path: "hs100.bin",
+			fake: &fakeFirmwareDevice{stalePolls: 1, downPolls: 1, strip: true},
+			socket: true,
+			timeout: 5 * time.Second,
+			stages: []FirmwareStage{FirmwareDownloading, FirmwareDownloading, FirmwareFlashing, FirmwareRebooting, FirmwareDone},
+			ratios: []int{50, 100, 100, 100, 100},
+			updated: true,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			fake := newFakeFirmwareDevice(t, tc.fake)
+			oldVersion := fake.Version()
+			dev := &BaseDevice{Addr: "127.0.0.1"}
+			dev.SetRetryPolicy(NoRetry)
+			dev.SetTransport(addrTransport(fake.Addr()))
+			err := dev.Update()
+			if err != nil {
+				t.Fatal(err)
+			}
+			target := dev.AsConcrete()
+			if tc.socket {
+				target = target.(*SmartStrip).Children()[0]
+			}
+			fw, ok := target.Module(FirmwareModule).(*Firmware)
+			if !ok {
+				t.Fatal("no firmware module")
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), tc.timeout)
+			defer cancel()
+			url := "http://" + srv.Addr().String() + "/" + tc.path
+			ch, err := fw.Update(ctx, url, 10 * time.Millisecond)
+			if err != nil {
+				t.Fatal(err)
+			}
+			progress := []*FirmwareProgress{}
+			for p := range ch {
+				progress = append(progress, p)
+			}
+			if len(progress) != len(tc.stages) {
+				t.Fatalf("got %d progress values, want %d", len(progress), len(tc.stages))
+			}
+			for i, p := range progress {
+				if p.Stage != tc.stages[i] || p.Ratio != tc.ratios[i] {
+					t.Errorf("progress %d = %s %d%%, want %s %d%%", i, p.Stage, p.Ratio, tc.stages[i], tc.ratios[i])
+				}
+			}
+			last := progress[len(progress) - 1]
+			if tc.err == nil && last.Err != nil {
+				t.Errorf("unexpected error: %s", last.Err)
+			}
+			if tc.err != nil && !tc.err(last.Err) {
+				t.Errorf("unexpected error: %v", last.Err)
+			}
+			updated := dev.GetSysInfo().SoftwareVersion != oldVersion
+			if updated != tc.updated {
+				t.Errorf("software version %s, updated = %t, want %t", dev.GetSysInfo().SoftwareVersion, updated, tc.updated)
+			}
+		})
+	}
+	if srv.Downloads() != 4 {
+		t.Errorf("image downloaded %d times, want 4", srv.Downloads())
+	}
+}
@@ -0,0 +1,259 @@
+package kasa
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const DEFAULT_GROUP_CONCURRENCY = 8
+
+var ErrNotSwitch = errors.New("device can't be switched on and off")
+var ErrNotDimmable = errors.New("device is not dimmable")
+
+type GroupState string
+
+const (
+	GroupAllOn = GroupState("on")
+	GroupAllOff = GroupState("off")
+	GroupMixed = GroupState("mixed")
+	// GroupEmpty is the state of a group with no switchable devices.
+	GroupEmpty = GroupState("empty")
+)
+
+// GroupResults maps the key of each device a group operation ran on to
+// the error it returned, nil on success.  The key is the device ID, or the
+// MAC or IP address for devices that haven't reported one; see groupKey.
+type GroupResults map[string]error
+
+// Failed returns the results that are errors.
+func (res GroupResults) Failed() GroupResults {
+	failed := GroupResults{}
+	for id, err := range res {
+		if err != nil {
+			failed[id] = err
+		}
+	}
+	return failed
+}
+
+// Err returns a *GroupError if any device failed, or nil.
+func (res GroupResults) Err() error {
+	failed := res.Failed()
+	if len(failed) == 0 {
+		return nil
+	}
+	return &GroupError{Failed: failed, Total: len(res)}
+}
+
+type GroupError struct {
+	Failed GroupResults
+	Total int
+}
+
+func (gerr *GroupError) Error() string {
+	ids := make([]string, 0, len(gerr.Failed))
+	for id := range gerr.Failed {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	msgs := make([]string, len(ids))
+	for i, id := range ids {
+		msgs[i] = fmt.Sprintf("%s: %s", id, gerr.Failed[id])
+	}
+	return fmt.Sprintf("%d of %d devices failed: %s", len(gerr.Failed), gerr.Total, strings.Join(msgs, "; "))
+}
+
+// Group is a set of devices, such as a room, that are operated on
+// together.  Operations run on up to Concurrency devices at once.
+type Group struct {
+	Name string
+	Concurrency int
+	devices []SmartDevice
+	lock sync.RWMutex
+}
+
+func NewGroup(name string, devices ...SmartDevice) *Group {
+	g := &Group{Name: name, Concurrency: DEFAULT_GROUP_CONCURRENCY}
+	g.Add(devices...)
+	return g
+}
+
+// Add adds devices to the group, ignoring any it already has.
+func (g *Group) Add(devices ...SmartDevice) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	for _, dev := range devices {
+		if g.indexOf(groupKey(dev)) < 0 {
+			g.devices = append(g.devices, dev)
+		}
+	}
+}
+
+// Remove removes the device with the given key, as used in GroupResults,
+// reporting whether it was in the group.
+func (g *Group) Remove(id string) bool {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	idx := g.indexOf(id)
+	if idx < 0 {
+		return false
+	}
+	g.devices = append(g.devices[:idx], g.devices[idx+1:]...)
+	return true
+}
+
+func (g *Group) indexOf(id string) int {
+	for i, dev := range g.devices {
+		if groupKey(dev) == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// groupKey identifies dev within a group.  Devices that haven't reported
+// an ID or MAC address yet, e.g. ones loaded by address alone, fall back
+// to their IP address so they don't all collide on "".
+func groupKey(dev SmartDevice) string {
+	if key := deviceKey(dev); key != "" {
+		return key
+	}
+	return dev.IP()
+}
+
+func (g *Group) Devices() []SmartDevice {
+	g.lock.RLock()
+	defer g.lock.RUnlock()
+	return append([]SmartDevice{}, g.devices...)
+}
+
+func (g *Group) Len() int {
+	g.lock.RLock()
+	defer g.lock.RUnlock()
+	return len(g.devices)
+}
+
+type groupResult struct {
+	id string
+	err error
+}
+
+// Do runs fn on every device in the group.  Devices that haven't finished
+// when ctx is done are reported with ctx's error; fn isn't interrupted,
+// but Do doesn't wait for it.
+func (g *Group) Do(ctx context.Context, fn func(ctx context.Context, dev SmartDevice) error) GroupResults {
+	devices := g.Devices()
+	results := GroupResults{}
+	if ctx.Err() != nil {
+		for _, dev := range devices {
+			results[groupKey(dev)] = ctx.Err()
+		}
+		return results
+	}
+	concurrency := g.Concurrency
+	if concurrency <= 0 {
+		concurrency = DEFAULT_GROUP_CONCURRENCY
+	}
+	sem := make(chan struct{}, concurrency)
+	// buffered so stragglers can finish after Do has returned
+	ch := make(chan groupResult, len(devices))
+	go func() {
+		for _, dev := range devices {
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+			go func(dev SmartDevice) {
+				defer func() { <-sem }()
+				ch <- groupResult{id: groupKey(dev), err: fn(ctx, dev)}
+			}(dev)
+		}
+	}()
+	for range devices {
+		select {
+		case res := <-ch:
+			results[res.id] = res.err
+		case <-ctx.Done():
+			for _, dev := range devices {
+				if _, ok := results[groupKey(dev)]; !ok {
+					results[groupKey(dev)] = ctx.Err()
+				}
+			}
+			return results
+		}
+	}
+	return results
+}
+
+// Switches runs fn on every device in the group that can be switched on
+// and off.  Other devices are reported with ErrNotSwitch.
+func (g *Group) Switches(ctx context.Context, fn func(sw Switch) error) GroupResults {
+	return g.Do(ctx, func(ctx context.Context, dev SmartDevice) error {
+		sw, ok := dev.(Switch)
+		if !ok {
+			return ErrNotSwitch
+		}
+		return fn(sw)
+	})
+}
+
+func (g *Group) TurnOn(ctx context.Context) GroupResults {
+	return g.Switches(ctx, func(sw Switch) error {
+		return sw.TurnOn()
+	})
+}
+
+func (g *Group) TurnOff(ctx context.Context) GroupResults {
+	return g.Switches(ctx, func(sw Switch) error {
+		return sw.TurnOff()
+	})
+}
+
+// SetBrightness sets the brightness of every dimmable device in the
+// group.  Other devices are reported with ErrNotDimmable.
+func (g *Group) SetBrightness(ctx context.Context, brightness int) GroupResults {
+	return g.Do(ctx, func(ctx context.Context, dev SmartDevice) error {
+		dimmer, ok := dev.(Dimmer)
+		if !ok || !dev.IsDimmable() {
+			return ErrNotDimmable
+		}
+		return dimmer.SetBrightness(brightness)
+	})
+}
+
+// Update refreshes the cached sysinfo of every device in the group.
+func (g *Group) Update(ctx context.Context) GroupResults {
+	return g.Do(ctx, func(ctx context.Context, dev SmartDevice) error {
+		return dev.Update()
+	})
+}
+
+// State reports whether the group's switchable devices are all on, all
+// off, or a mix, going by their cached state.
+func (g *Group) State() GroupState {
+	on, off := 0, 0
+	for _, dev := range g.Devices() {
+		if _, ok := dev.(Switch); !ok {
+			continue
+		}
+		if dev.IsOn() {
+			on++
+		} else {
+			off++
+		}
+	}
+	switch {
+	case on == 0 && off == 0:
+		return GroupEmpty
+	case off == 0:
+		return GroupAllOn
+	case on == 0:
+		return GroupAllOff
+	}
+	return GroupMixed
+}
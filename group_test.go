@@ -0,0 +1,140 @@
+package kasa
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestGroupAdd(t *testing.T) {
+	g := NewGroup("test",
+		registryDevice("ID1", "AA:BB:CC:DD:EE:01", "192.0.2.1"),
+		registryDevice("", "AA:BB:CC:DD:EE:02", "192.0.2.2"),
+		// not identified yet, so only their addresses tell them apart
+		registryDevice("", "", "192.0.2.3"),
+		registryDevice("", "", "192.0.2.4"),
+		// already in the group
+		registryDevice("ID1", "AA:BB:CC:DD:EE:01", "192.0.2.5"),
+		registryDevice("", "", "192.0.2.3"),
+	)
+	if g.Len() != 4 {
+		t.Fatalf("%d devices, want 4", g.Len())
+	}
+	results := g.Do(context.Background(), func(ctx context.Context, dev SmartDevice) error {
+		return nil
+	})
+	for _, key := range []string{"ID1", "AA:BB:CC:DD:EE:02", "192.0.2.3", "192.0.2.4"} {
+		if err, ok := results[key]; !ok || err != nil {
+			t.Errorf("results[%s] = %v, %t, want nil, true", key, err, ok)
+		}
+	}
+	if !g.Remove("192.0.2.3") || g.Remove("192.0.2.3") || g.Len() != 3 {
+		t.Errorf("removing by address left %d devices", g.Len())
+	}
+}
+
+func TestGroupDoConcurrency(t *testing.T) {
+	g := NewGroup("test")
+	for i := 1; i <= 6; i++ {
+		g.Add(registryDevice(fmt.Sprintf("ID%d", i), "", fmt.Sprintf("192.0.2.%d", i)))
+	}
+	g.Concurrency = 2
+	var lock sync.Mutex
+	active, most := 0, 0
+	results := g.Do(context.Background(), func(ctx context.Context, dev SmartDevice) error {
+		lock.Lock()
+		active++
+		if active > most {
+			most = active
+		}
+		lock.Unlock()
+		time.Sleep(20 * time.Millisecond)
+		lock.Lock()
+		active--
+		lock.Unlock()
+		return nil
+	})
+	if len(results) != 6 || results.Err() != nil {
+		t.Errorf("results = %v", results)
+	}
+	if most != 2 {
+		t.Errorf("%d devices operated on at once, want 2", most)
+	}
+}
+
+func TestGroupDoDeadline(t *testing.T) {
+	g := NewGroup("test",
+		registryDevice("FAST", "", "192.0.2.1"),
+		registryDevice("SLOW", "", "192.0.2.2"),
+		registryDevice("QUEUED", "", "192.0.2.3"),
+	)
+	g.Concurrency = 2
+	release := make(chan struct{})
+	defer close(release)
+	ctx, cancel := context.WithTimeout(context.Background(), 50 * time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	results := g.Do(ctx, func(ctx context.Context, dev SmartDevice) error {
+		if dev.DeviceID() != "FAST" {
+			// ignores ctx, like a device query stuck in a read
+			<-release
+		}
+		return nil
+	})
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Do waited %s for devices past the deadline", elapsed)
+	}
+	want := map[string]error{"FAST": nil, "SLOW": context.DeadlineExceeded, "QUEUED": context.DeadlineExceeded}
+	if len(results) != len(want) {
+		t.Fatalf("results = %v, want %v", results, want)
+	}
+	for id, err := range want {
+		if results[id] != err {
+			t.Errorf("results[%s] = %v, want %v", id, results[id], err)
+		}
+	}
+	// a context that's already done runs nothing
+	ran := false
+	results = g.Do(ctx, func(ctx context.Context, dev SmartDevice) error {
+		ran = true
+		return nil
+	})
+	if ran || len(results.Failed()) != 3 {
+		t.Errorf("done context: ran = %t, results = %v", ran, results)
+	}
+}
+
+func TestGroupDoErrors(t *testing.T) {
+	errOffline := errors.New("offline")
+	g := NewGroup("test",
+		registryDevice("ID1", "", "192.0.2.1"),
+		registryDevice("ID2", "", "192.0.2.2"),
+		registryDevice("", "", "192.0.2.3"),
+	)
+	results := g.Do(context.Background(), func(ctx context.Context, dev SmartDevice) error {
+		if dev.DeviceID() == "ID1" {
+			return nil
+		}
+		return fmt.Errorf("%s: %w", dev.IP(), errOffline)
+	})
+	failed := results.Failed()
+	if len(failed) != 2 || failed["ID2"] == nil || failed["192.0.2.3"] == nil {
+		t.Fatalf("failed = %v", failed)
+	}
+	var gerr *GroupError
+	if !errors.As(results.Err(), &gerr) || gerr.Total != 3 || len(gerr.Failed) != 2 {
+		t.Fatalf("Err() = %v", results.Err())
+	}
+	for id, err := range gerr.Failed {
+		if !errors.Is(err, errOffline) {
+			t.Errorf("%s failed with %v, want %v", id, err, errOffline)
+		}
+	}
+	want := "2 of 3 devices failed: 192.0.2.3: 192.0.2.3: offline; ID2: 192.0.2.2: offline"
+	if gerr.Error() != want {
+		t.Errorf("Error() = %q, want %q", gerr.Error(), want)
+	}
+}
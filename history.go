@@ -0,0 +1,78 @@
+package kasa
+
+import (
+	"sync"
+	"time"
+)
+
+// QueryRecord describes one request sent to a device and what came back.
+type QueryRecord struct {
+	Time time.Time `json:"time"`
+	Duration time.Duration `json:"duration"`
+	Target string `json:"target"`
+	Command string `json:"command"`
+	Request interface{} `json:"request"`
+	Response interface{} `json:"response,omitempty"`
+	Error error `json:"-"`
+}
+
+// QueryHistory is a fixed-size ring buffer of the most recent queries made
+// to a device.  It is safe for concurrent use.
+type QueryHistory struct {
+	lock sync.Mutex
+	records []QueryRecord
+	next int
+	full bool
+}
+
+func NewQueryHistory(size int) *QueryHistory {
+	if size < 1 {
+		size = 1
+	}
+	return &QueryHistory{records: make([]QueryRecord, size)}
+}
+
+func (h *QueryHistory) Add(rec QueryRecord) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.records[h.next] = rec
+	h.next += 1
+	if h.next == len(h.records) {
+		h.next = 0
+		h.full = true
+	}
+}
+
+func (h *QueryHistory) Len() int {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if h.full {
+		return len(h.records)
+	}
+	return h.next
+}
+
+// Records returns a copy of the buffered records, oldest first.
+func (h *QueryHistory) Records() []QueryRecord {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if !h.full {
+		out := make([]QueryRecord, h.next)
+		copy(out, h.records[:h.next])
+		return out
+	}
+	out := make([]QueryRecord, 0, len(h.records))
+	out = append(out, h.records[h.next:]...)
+	out = append(out, h.records[:h.next]...)
+	return out
+}
+
+func (h *QueryHistory) Clear() {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	for i := range h.records {
+		h.records[i] = QueryRecord{}
+	}
+	h.next = 0
+	h.full = false
+}
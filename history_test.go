@@ -0,0 +1,80 @@
+package kasa
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestQueryHistory(t *testing.T) {
+	tests := []struct {
+		size int
+		adds int
+		want []string
+	}{
+		{size: 3, adds: 0, want: []string{}},
+		{size: 3, adds: 2, want: []string{"cmd0", "cmd1"}},
+		{size: 3, adds: 3, want: []string{"cmd0", "cmd1", "cmd2"}},
+		{size: 3, adds: 4, want: []string{"cmd1", "cmd2", "cmd3"}},
+		{size: 3, adds: 7, want: []string{"cmd4", "cmd5", "cmd6"}},
+		{size: 0, adds: 2, want: []string{"cmd1"}},
+	}
+	for _, tc := range tests {
+		h := NewQueryHistory(tc.size)
+		for i := 0; i < tc.adds; i++ {
+			h.Add(QueryRecord{Command: fmt.Sprintf("cmd%d", i)})
+		}
+		got := []string{}
+		for _, rec := range h.Records() {
+			got = append(got, rec.Command)
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("size %d after %d adds: records %v, want %v", tc.size, tc.adds, got, tc.want)
+		}
+		if h.Len() != len(tc.want) {
+			t.Errorf("size %d after %d adds: Len() = %d, want %d", tc.size, tc.adds, h.Len(), len(tc.want))
+		}
+		h.Clear()
+		if h.Len() != 0 || len(h.Records()) != 0 {
+			t.Errorf("size %d after %d adds: history not empty after Clear", tc.size, tc.adds)
+		}
+	}
+}
+
+func TestDeviceHistory(t *testing.T) {
+	fail := false
+	dev := &BaseDevice{Addr: "192.0.2.1"}
+	dev.SetRetryPolicy(NoRetry)
+	dev.SetTransport(funcTransport(func(host string, req interface{}, dst interface{}) error {
+		if fail {
+			return &netError{errors.New("connection refused")}
+		}
+		return json.Unmarshal([]byte(`{"system":{"get_sysinfo":{"alias":"lamp"}}}`), dst)
+	}))
+	dev.Update()
+	if dev.History() != nil {
+		t.Fatal("history recorded before it was enabled")
+	}
+	dev.EnableHistory(2)
+	dev.Update()
+	fail = true
+	dev.Update()
+	records := dev.History().Records()
+	if len(records) != 2 {
+		t.Fatalf("%d records, want 2", len(records))
+	}
+	for i, rec := range records {
+		if rec.Target != "system" || rec.Command != "get_sysinfo" {
+			t.Errorf("record %d = %s.%s, want system.get_sysinfo", i, rec.Target, rec.Command)
+		}
+	}
+	if records[0].Error != nil || records[1].Error == nil {
+		t.Errorf("errors = %v, %v, want only the second query to fail", records[0].Error, records[1].Error)
+	}
+	dev.EnableHistory(0)
+	if dev.History() != nil {
+		t.Error("history still enabled after EnableHistory(0)")
+	}
+}
@@ -0,0 +1,263 @@
+package kasa
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+const DEFAULT_DISCOVER_TIMEOUT = 5 * time.Second
+
+type InventoryChild struct {
+	ID string `json:"id"`
+	Alias string `json:"alias"`
+}
+
+// InventoryEntry is what the inventory remembers about a device.  SysInfo
+// is the last sysinfo the device reported, from which the concrete device
+// is rebuilt.
+type InventoryEntry struct {
+	DeviceID string `json:"device_id"`
+	IP string `json:"ip"`
+	MAC string `json:"mac"`
+	Model string `json:"model"`
+	Alias string `json:"alias"`
+	Type DeviceType `json:"type"`
+	Children []*InventoryChild `json:"children,omitempty"`
+	LastSeen time.Time `json:"last_seen"`
+	SysInfo *SysInfo `json:"sysinfo"`
+}
+
+// Inventory is an on-disk cache of known devices, keyed by device ID, so
+// tools can start without waiting for discovery.
+type Inventory struct {
+	Path string `json:"-"`
+	Entries map[string]*InventoryEntry `json:"devices"`
+	lock sync.Mutex
+	devices map[string]SmartDevice
+	revalidating sync.WaitGroup
+}
+
+func DefaultInventoryPath() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "kasa", "inventory.json"), nil
+}
+
+func NewInventory(path string) *Inventory {
+	return &Inventory{
+		Path: path,
+		Entries: map[string]*InventoryEntry{},
+		devices: map[string]SmartDevice{},
+	}
+}
+
+// LoadInventory reads an inventory file.  A missing file yields an empty
+// inventory.
+func LoadInventory(path string) (*Inventory, error) {
+	inv := NewInventory(path)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return inv, nil
+		}
+		return nil, err
+	}
+	err = json.Unmarshal(data, inv)
+	if err != nil {
+		return nil, err
+	}
+	if inv.Entries == nil {
+		inv.Entries = map[string]*InventoryEntry{}
+	}
+	return inv, nil
+}
+
+func (inv *Inventory) Save() error {
+	inv.lock.Lock()
+	data, err := json.MarshalIndent(inv, "", "  ")
+	inv.lock.Unlock()
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(inv.Path), 0755)
+	if err != nil {
+		return err
+	}
+	// write to a temp file and rename it, so a crash never leaves a
+	// truncated inventory behind
+	tmp := inv.Path + ".tmp"
+	err = os.WriteFile(tmp, data, 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, inv.Path)
+}
+
+func inventoryEntry(dev SmartDevice) *InventoryEntry {
+	sysinfo := dev.GetSysInfo()
+	if sysinfo == nil {
+		return nil
+	}
+	entry := &InventoryEntry{
+		DeviceID: dev.DeviceID(),
+		IP: dev.IP(),
+		MAC: dev.MAC(),
+		Model: dev.Model(),
+		Alias: dev.Alias(),
+		Type: dev.DeviceType(),
+		LastSeen: sysinfo.LastUpdate,
+		SysInfo: sysinfo,
+	}
+	for _, child := range sysinfo.Children {
+		entry.Children = append(entry.Children, &InventoryChild{ID: child.ID, Alias: child.Alias})
+	}
+	return entry
+}
+
+// Add records devices in the inventory, replacing what was known about
+// them.  Strip sockets and devices that can't be spoken to with the
+// legacy protocol are skipped.
+func (inv *Inventory) Add(devices ...SmartDevice) {
+	inv.lock.Lock()
+	defer inv.lock.Unlock()
+	for _, dev := range devices {
+		if dev.IsStripSocket() || dev.DeviceType() == DeviceTypeEncrypted {
+			continue
+		}
+		entry := inventoryEntry(dev)
+		if entry == nil || entry.DeviceID == "" {
+			continue
+		}
+		inv.Entries[entry.DeviceID] = entry
+		inv.devices[entry.DeviceID] = dev
+	}
+}
+
+func (entry *InventoryEntry) device() SmartDevice {
+	info := &Query{System: &SysInfoResponse{SysInfo: entry.SysInfo}}
+	dev := &BaseDevice{Addr: entry.IP, Info: info}
+	return dev.AsConcrete()
+}
+
+// Devices returns the inventoried devices, sorted by alias.  They are
+// rebuilt from the cached sysinfo without any network traffic, and the
+// same values are returned on every call, so a revalidation updates
+// devices already handed out.
+func (inv *Inventory) Devices() []SmartDevice {
+	inv.lock.Lock()
+	defer inv.lock.Unlock()
+	devices := make([]SmartDevice, 0, len(inv.Entries))
+	for id, entry := range inv.Entries {
+		dev, ok := inv.devices[id]
+		if !ok {
+			dev = entry.device()
+			inv.devices[id] = dev
+		}
+		devices = append(devices, dev)
+	}
+	sort.Slice(devices, func(i, j int) bool {
+		return devices[i].Alias() < devices[j].Alias()
+	})
+	return devices
+}
+
+// Revalidate refreshes every inventoried device, all at once, giving them
+// up to timeout to answer.  Devices that don't answer at their cached
+// address are looked for with discovery for up to timeout more, and are
+// moved to their new address if found.  The inventory is saved afterward.
+func (inv *Inventory) Revalidate(ctx context.Context, timeout time.Duration) error {
+	devices := inv.Devices()
+	group := NewGroup("inventory", devices...)
+	group.Concurrency = len(devices)
+	updateCtx, cancel := context.WithTimeout(ctx, timeout)
+	results := group.Update(updateCtx)
+	cancel()
+	missing := map[string]SmartDevice{}
+	for _, dev := range devices {
+		err := results[groupKey(dev)]
+		if err != nil {
+			if Debug {
+				log.Printf("%s (%s) not answering at %s: %s", dev.Alias(), dev.DeviceID(), dev.IP(), err)
+			}
+			missing[dev.DeviceID()] = dev
+			continue
+		}
+		inv.Add(dev)
+	}
+	if len(missing) > 0 {
+		ctx, cancel := context.WithTimeout(ctx, timeout)
+		defer cancel()
+		ch, err := DiscoverStream(ctx, DEFAULT_FIND_RETRY)
+		if err != nil {
+			return err
+		}
+		for found := range ch {
+			dev, ok := missing[found.DeviceID()]
+			if !ok {
+				continue
+			}
+			base := baseDevice(dev)
+			if base != nil {
+				base.SetIP(found.IP())
+				base.setInfo(baseDevice(found).getInfo())
+			}
+			inv.Add(dev)
+			delete(missing, found.DeviceID())
+			if len(missing) == 0 {
+				break
+			}
+		}
+	}
+	return inv.Save()
+}
+
+// RevalidateInBackground starts Revalidate in a goroutine.  Use Wait to
+// block until it has finished.
+func (inv *Inventory) RevalidateInBackground(ctx context.Context, timeout time.Duration) {
+	inv.revalidating.Add(1)
+	go func() {
+		defer inv.revalidating.Done()
+		err := inv.Revalidate(ctx, timeout)
+		if err != nil {
+			log.Println("error revalidating inventory:", err)
+		}
+	}()
+}
+
+func (inv *Inventory) Wait() {
+	inv.revalidating.Wait()
+}
+
+// LoadOrDiscover returns the devices in the inventory at path and
+// revalidates them in the background.  If the inventory is empty, it runs
+// discovery instead and saves what it finds.
+func LoadOrDiscover(ctx context.Context, path string) (*Inventory, []SmartDevice, error) {
+	inv, err := LoadInventory(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(inv.Entries) > 0 {
+		inv.RevalidateInBackground(ctx, DEFAULT_DISCOVER_TIMEOUT)
+		return inv, inv.Devices(), nil
+	}
+	devices, err := Discover(DEFAULT_DISCOVER_TIMEOUT)
+	if err != nil {
+		return nil, nil, err
+	}
+	inv.Add(devices...)
+	err = inv.Save()
+	if err != nil {
+		log.Println("error saving inventory:", err)
+	}
+	return inv, inv.Devices(), nil
+}
@@ -0,0 +1,74 @@
+package kasa
+
+import (
+	_ "embed"
+	"encoding/json"
+	"log"
+	"strings"
+	"sync"
+)
+
+type ColorTempRange struct {
+	Min int `json:"min"`
+	Max int `json:"max"`
+}
+
+// ModelInfo describes what a hardware model can do.
+type ModelInfo struct {
+	Model string `json:"model"`
+	Type DeviceType `json:"type"`
+	Relay bool `json:"relay,omitempty"`
+	Dimmable bool `json:"dimmable,omitempty"`
+	Color bool `json:"color,omitempty"`
+	ColorTemp *ColorTempRange `json:"color_temp,omitempty"`
+	Emeter bool `json:"emeter,omitempty"`
+	Children int `json:"children,omitempty"`
+	LED bool `json:"led,omitempty"`
+}
+
+//go:embed models.json
+var modelsJSON []byte
+
+var models map[string]*ModelInfo
+var modelsOnce sync.Once
+
+func loadModels() map[string]*ModelInfo {
+	modelsOnce.Do(func() {
+		list := []*ModelInfo{}
+		err := json.Unmarshal(modelsJSON, &list)
+		if err != nil {
+			log.Println("error loading model table:", err)
+		}
+		models = make(map[string]*ModelInfo, len(list))
+		for _, info := range list {
+			models[info.Model] = info
+		}
+	})
+	return models
+}
+
+// baseModel strips the region suffix from a model name, e.g. "HS100(US)"
+// becomes "HS100".
+func baseModel(model string) string {
+	if idx := strings.Index(model, "("); idx >= 0 {
+		model = model[:idx]
+	}
+	return strings.ToUpper(strings.TrimSpace(model))
+}
+
+// LookupModel returns the capabilities of a model, given either its bare
+// name or the name a device reports (with region suffix), or nil if the
+// model isn't in the table.
+func LookupModel(model string) *ModelInfo {
+	return loadModels()[baseModel(model)]
+}
+
+// Models returns every model in the table.
+func Models() []*ModelInfo {
+	table := loadModels()
+	list := make([]*ModelInfo, 0, len(table))
+	for _, info := range table {
+		list = append(list, info)
+	}
+	return list
+}
@@ -0,0 +1,91 @@
+package kasa
+
+import (
+	"testing"
+)
+
+func TestLookupModel(t *testing.T) {
+	tests := []struct {
+		model string
+		devType DeviceType
+		children int
+		color bool
+		tempMax int
+	}{
+		{model: "HS100(US)", devType: DeviceTypePlug},
+		{model: "hs100", devType: DeviceTypePlug},
+		{model: " HS300(US) ", devType: DeviceTypeStrip, children: 6},
+		{model: "KP303(UK)", devType: DeviceTypeStrip, children: 3},
+		{model: "HS220(US)", devType: DeviceTypeDimmer},
+		{model: "KL130(EU)", devType: DeviceTypeBulb, color: true, tempMax: 9000},
+		{model: "KL120", devType: DeviceTypeBulb, tempMax: 6500},
+		{model: "KL430(US)", devType: DeviceTypeLightStrip, color: true, tempMax: 9000},
+		{model: "XX999(US)"},
+		{model: ""},
+	}
+	for _, tc := range tests {
+		info := LookupModel(tc.model)
+		if tc.devType == "" {
+			if info != nil {
+				t.Errorf("LookupModel(%q) = %s, want nil", tc.model, info.Model)
+			}
+			continue
+		}
+		if info == nil {
+			t.Errorf("LookupModel(%q) = nil, want %s", tc.model, tc.devType)
+			continue
+		}
+		if info.Type != tc.devType || info.Children != tc.children || info.Color != tc.color {
+			t.Errorf("LookupModel(%q) = %s with %d children, color %t; want %s with %d, color %t", tc.model, info.Type, info.Children, info.Color, tc.devType, tc.children, tc.color)
+		}
+		tempMax := 0
+		if info.ColorTemp != nil {
+			tempMax = info.ColorTemp.Max
+		}
+		if tempMax != tc.tempMax {
+			t.Errorf("LookupModel(%q) color temp max = %d, want %d", tc.model, tempMax, tc.tempMax)
+		}
+	}
+}
+
+func TestModelTableTypes(t *testing.T) {
+	known := map[DeviceType]bool{
+		DeviceTypePlug: true,
+		DeviceTypeStrip: true,
+		DeviceTypeDimmer: true,
+		DeviceTypeBulb: true,
+		DeviceTypeLightStrip: true,
+	}
+	for _, info := range Models() {
+		if !known[info.Type] {
+			t.Errorf("%s has unexpected type %q", info.Model, info.Type)
+		}
+		if (info.Type == DeviceTypeStrip) != (info.Children > 0) {
+			t.Errorf("%s is a %s with %d children", info.Model, info.Type, info.Children)
+		}
+		if baseModel(info.Model) != info.Model {
+			t.Errorf("model %q isn't in canonical form", info.Model)
+		}
+	}
+}
+
+func TestDeviceTypeUnknownModel(t *testing.T) {
+	tests := []struct {
+		sysinfo string
+		devType DeviceType
+	}{
+		{`{"model":"HS100(US)","mic_type":"IOT.SMARTBULB"}`, DeviceTypePlug},
+		{`{"model":"ZZ1(US)","mic_type":"IOT.SMARTPLUGSWITCH"}`, DeviceTypePlug},
+		{`{"model":"ZZ1(US)","mic_type":"IOT.SMARTPLUGSWITCH","children":[{"id":"00"}]}`, DeviceTypeStrip},
+		{`{"model":"ZZ1(US)","dev_name":"Smart Wi-Fi Dimmer","mic_type":"IOT.SMARTPLUGSWITCH"}`, DeviceTypeDimmer},
+		{`{"model":"ZZ1(US)","mic_type":"IOT.SMARTBULB"}`, DeviceTypeBulb},
+		{`{"model":"ZZ1(US)","mic_type":"IOT.SMARTBULB","length":30}`, DeviceTypeLightStrip},
+		{`{"model":"ZZ1(US)"}`, DeviceTypeUnknown},
+	}
+	for _, tc := range tests {
+		dev := jsonTestDevice(t, tc.sysinfo)
+		if devType := dev.DeviceType(); devType != tc.devType {
+			t.Errorf("%s: type %s, want %s", tc.sysinfo, devType, tc.devType)
+		}
+	}
+}
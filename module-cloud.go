@@ -0,0 +1,154 @@
+package kasa
+
+import (
+	"sync"
+)
+
+// DEFAULT_CLOUD_SERVER is the server devices connect to out of the box.
+const DEFAULT_CLOUD_SERVER = "n-devs.tplinkcloud.com"
+
+type CloudInfo struct {
+	Username string `json:"username"`
+	Server string `json:"server"`
+	Binded int `json:"binded"`
+	CloudConnected int `json:"cld_connection"`
+	IllegalType int `json:"illegalType"`
+	StopConnect int `json:"stopConnect"`
+	TCSPStatus int `json:"tcspStatus"`
+	FirmwareDownloadPage string `json:"fwDlPage"`
+	TCSPInfo string `json:"tcspInfo"`
+	FirmwareNotifyType int `json:"fwNotifyType"`
+}
+
+// Bound reports whether the device is bound to a cloud account.
+func (info *CloudInfo) Bound() bool {
+	return info.Binded != 0
+}
+
+// Connected reports whether the device currently has a connection to its
+// cloud server.
+func (info *CloudInfo) Connected() bool {
+	return info.CloudConnected != 0
+}
+
+// FirmwareRelease is a firmware image the cloud offers for a device.
+type FirmwareRelease struct {
+	Type int `json:"fwType"`
+	URL string `json:"fwUrl"`
+	ReleaseDate string `json:"fwReleaseDate"`
+	ReleaseLog string `json:"fwReleaseLog"`
+	Title string `json:"fwTitle"`
+	Version string `json:"fwVer"`
+}
+
+type Cloud struct {
+	*ModuleBase
+}
+
+func (cloud *Cloud) Info() (*CloudInfo, error) {
+	res := &CloudInfo{}
+	err := cloud.Query(res, "get_info", nil)
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// Bind registers the device to a cloud account.  The device must be able
+// to reach its cloud server to do so.
+func (cloud *Cloud) Bind(username, password string) error {
+	return cloud.Query(nil, "bind", map[string]interface{}{"username": username, "password": password})
+}
+
+func (cloud *Cloud) Unbind() error {
+	return cloud.Query(nil, "unbind", nil)
+}
+
+// SetServerURL points the device at a different cloud server, such as a
+// local stand-in.  Use DEFAULT_CLOUD_SERVER to restore the original.
+func (cloud *Cloud) SetServerURL(server string) error {
+	return cloud.Query(nil, "set_server_url", map[string]interface{}{"server": server})
+}
+
+// FirmwareList asks the cloud server for firmware available for the
+// device.  It fails if the device is not connected to its server.
+func (cloud *Cloud) FirmwareList() ([]*FirmwareRelease, error) {
+	res := &struct{
+		Releases []*FirmwareRelease `json:"fw_list"`
+	}{}
+	err := cloud.Query(res, "get_intl_fw_list", nil)
+	if err != nil {
+		return nil, err
+	}
+	return res.Releases, nil
+}
+
+// CloudStatus is the cloud state of one device, as found by AuditCloud.
+// Err is set if the device couldn't be asked.
+type CloudStatus struct {
+	Device SmartDevice
+	Info *CloudInfo
+	Err error
+}
+
+// AuditCloud gets the cloud state of every device in parallel.  Strip
+// sockets share their strip's cloud state and are skipped.
+func AuditCloud(devices []SmartDevice) []*CloudStatus {
+	statuses := []*CloudStatus{}
+	for _, dev := range devices {
+		if dev.IsStripSocket() {
+			continue
+		}
+		statuses = append(statuses, &CloudStatus{Device: dev})
+	}
+	wg := &sync.WaitGroup{}
+	for _, status := range statuses {
+		wg.Add(1)
+		go func(status *CloudStatus) {
+			defer wg.Done()
+			cloud, ok := status.Device.Module(CloudModule).(*Cloud)
+			if !ok {
+				status.Err = errNoModule(status.Device, CloudModule)
+				return
+			}
+			status.Info, status.Err = cloud.Info()
+		}(status)
+	}
+	wg.Wait()
+	return statuses
+}
+
+// UnbindCloud unbinds every bound device and returns their cloud state
+// afterward.
+func UnbindCloud(devices []SmartDevice) []*CloudStatus {
+	statuses := AuditCloud(devices)
+	wg := &sync.WaitGroup{}
+	for _, status := range statuses {
+		if status.Err != nil || !status.Info.Bound() {
+			continue
+		}
+		wg.Add(1)
+		go func(status *CloudStatus) {
+			defer wg.Done()
+			cloud := status.Device.Module(CloudModule).(*Cloud)
+			status.Err = cloud.Unbind()
+			if status.Err != nil {
+				return
+			}
+			status.Info, status.Err = cloud.Info()
+		}(status)
+	}
+	wg.Wait()
+	return statuses
+}
+
+func init() {
+	RegisterModule(&ModuleSpec{
+		Name: CloudModule,
+		Target: iotTarget("cnCloud", "smartlife.iot.common.cloud"),
+		New: func(base *ModuleBase) Module {
+			return &Cloud{base}
+		},
+		Shared: true,
+	})
+}
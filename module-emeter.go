@@ -0,0 +1,131 @@
+package kasa
+
+import (
+	"time"
+)
+
+// EmeterRealtime is an instantaneous energy meter reading.  Older hardware
+// reports watts, volts, amps and kWh; newer hardware reports milliwatts,
+// millivolts, milliamps and watt-hours.  Use the accessor methods to get
+// consistent units from either.
+type EmeterRealtime struct {
+	Power float64 `json:"power,omitempty"`
+	PowerMW float64 `json:"power_mw,omitempty"`
+	Voltage float64 `json:"voltage,omitempty"`
+	VoltageMV float64 `json:"voltage_mv,omitempty"`
+	Current float64 `json:"current,omitempty"`
+	CurrentMA float64 `json:"current_ma,omitempty"`
+	Total float64 `json:"total,omitempty"`
+	TotalWH float64 `json:"total_wh,omitempty"`
+	ErrorCode int `json:"err_code,omitempty"`
+}
+
+func (rt *EmeterRealtime) Watts() float64 {
+	if rt.PowerMW != 0 {
+		return rt.PowerMW / 1000
+	}
+	return rt.Power
+}
+
+func (rt *EmeterRealtime) Volts() float64 {
+	if rt.VoltageMV != 0 {
+		return rt.VoltageMV / 1000
+	}
+	return rt.Voltage
+}
+
+func (rt *EmeterRealtime) Amps() float64 {
+	if rt.CurrentMA != 0 {
+		return rt.CurrentMA / 1000
+	}
+	return rt.Current
+}
+
+func (rt *EmeterRealtime) KWh() float64 {
+	if rt.TotalWH != 0 {
+		return rt.TotalWH / 1000
+	}
+	return rt.Total
+}
+
+type EmeterDayStat struct {
+	Year int `json:"year"`
+	Month time.Month `json:"month"`
+	Day int `json:"day"`
+	Energy float64 `json:"energy,omitempty"`
+	EnergyWH float64 `json:"energy_wh,omitempty"`
+}
+
+func (stat *EmeterDayStat) KWh() float64 {
+	if stat.EnergyWH != 0 {
+		return stat.EnergyWH / 1000
+	}
+	return stat.Energy
+}
+
+type EmeterMonthStat struct {
+	Year int `json:"year"`
+	Month time.Month `json:"month"`
+	Energy float64 `json:"energy,omitempty"`
+	EnergyWH float64 `json:"energy_wh,omitempty"`
+}
+
+func (stat *EmeterMonthStat) KWh() float64 {
+	if stat.EnergyWH != 0 {
+		return stat.EnergyWH / 1000
+	}
+	return stat.Energy
+}
+
+type Emeter struct {
+	*ModuleBase
+}
+
+func (em *Emeter) Realtime() (*EmeterRealtime, error) {
+	res := &EmeterRealtime{}
+	err := em.Query(res, "get_realtime", nil)
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+func (em *Emeter) DayStats(year int, month time.Month) ([]*EmeterDayStat, error) {
+	res := &struct{
+		Days []*EmeterDayStat `json:"day_list"`
+	}{}
+	err := em.Query(res, "get_daystat", map[string]interface{}{"year": year, "month": int(month)})
+	if err != nil {
+		return nil, err
+	}
+	return res.Days, nil
+}
+
+func (em *Emeter) MonthStats(year int) ([]*EmeterMonthStat, error) {
+	res := &struct{
+		Months []*EmeterMonthStat `json:"month_list"`
+	}{}
+	err := em.Query(res, "get_monthstat", map[string]interface{}{"year": year})
+	if err != nil {
+		return nil, err
+	}
+	return res.Months, nil
+}
+
+// Erase clears the stored day and month statistics.
+func (em *Emeter) Erase() error {
+	return em.Query(nil, "erase_emeter_stat", nil)
+}
+
+func init() {
+	RegisterModule(&ModuleSpec{
+		Name: EmeterModule,
+		Target: iotTarget("emeter", "smartlife.iot.common.emeter"),
+		Detect: func(dev SmartDevice) bool {
+			return dev.HasEmeter()
+		},
+		New: func(base *ModuleBase) Module {
+			return &Emeter{base}
+		},
+	})
+}
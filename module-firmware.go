@@ -0,0 +1,273 @@
+package kasa
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+)
+
+const DEFAULT_FIRMWARE_POLL = 2 * time.Second
+
+type FirmwareStage string
+
+const (
+	FirmwareDownloading = FirmwareStage("downloading")
+	FirmwareFlashing = FirmwareStage("flashing")
+	FirmwareRebooting = FirmwareStage("rebooting")
+	FirmwareDone = FirmwareStage("done")
+	FirmwareFailed = FirmwareStage("failed")
+)
+
+// FirmwareDownloadState is the device's report on a firmware download.
+// Status is negative if the download failed, e.g. because the image
+// couldn't be fetched.  Ratio is the percentage downloaded.  FlashTime
+// and RebootTime are the seconds the device expects flashing and
+// rebooting to take.
+type FirmwareDownloadState struct {
+	Status int `json:"status"`
+	Ratio int `json:"ratio"`
+	FlashTime int `json:"flash_time"`
+	RebootTime int `json:"reboot_time"`
+}
+
+// FirmwareProgress is sent on the channel returned by Firmware.Update as
+// the update moves along.  The last value sent has Stage FirmwareDone or
+// FirmwareFailed, and Err is set on failure.
+type FirmwareProgress struct {
+	Stage FirmwareStage
+	Ratio int
+	Err error
+}
+
+// Firmware downloads and installs firmware images.  The device fetches the
+// image itself, so the URL must be reachable from the device.
+type Firmware struct {
+	*ModuleBase
+}
+
+// List returns the firmware the cloud offers for the device.
+func (fw *Firmware) List() ([]*FirmwareRelease, error) {
+	cloud, ok := fw.Device().Module(CloudModule).(*Cloud)
+	if !ok {
+		return nil, &DeviceError{Target: fw.Target(), Command: "get_intl_fw_list", Code: -1, Message: "no cloud module"}
+	}
+	return cloud.FirmwareList()
+}
+
+// Download starts downloading the firmware image at url.  Poll
+// DownloadState to see how it's going.
+func (fw *Firmware) Download(url string) error {
+	return fw.Query(nil, "download_firmware", map[string]interface{}{"url": url})
+}
+
+func (fw *Firmware) DownloadState() (*FirmwareDownloadState, error) {
+	res := &FirmwareDownloadState{}
+	err := fw.Query(res, "get_download_state", nil)
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// Flash installs the downloaded image.  The device reboots afterward.
+func (fw *Firmware) Flash() error {
+	return fw.Query(nil, "flash_firmware", nil)
+}
+
+// Update downloads the image at url, flashes it once it's downloaded, and
+// waits for the device to reboot.  The device counts as rebooted once it
+// answers with a different software version, or answers again after
+// dropping off the network or after its reported reboot time, so
+// reflashing the same version finishes too.  Progress is sent on the
+// returned channel, polling every interval.  The last value sent is always
+// FirmwareDone or FirmwareFailed, with ctx's error if ctx is done first,
+// and the channel is closed after it.  Progress values the caller hasn't
+// read by then may be dropped.  Only errors reading the current version or
+// requesting the download are returned directly.
+func (fw *Firmware) Update(ctx context.Context, url string, interval time.Duration) (chan *FirmwareProgress, error) {
+	if interval <= 0 {
+		interval = DEFAULT_FIRMWARE_POLL
+	}
+	// the firmware belongs to the whole device, so on a strip socket
+	// read the strip's version
+	dev := fw.base
+	err := dev.Update()
+	if err != nil {
+		return nil, err
+	}
+	sysinfo := dev.GetSysInfo()
+	if sysinfo == nil {
+		return nil, fmt.Errorf("%s reported no sysinfo", dev.IP())
+	}
+	oldVersion := sysinfo.SoftwareVersion
+	err = fw.Download(url)
+	if err != nil {
+		return nil, err
+	}
+	ch := make(chan *FirmwareProgress, 1)
+	go func() {
+		defer close(ch)
+		// the final value never blocks: drop an unread progress value to
+		// make room for it in the buffer
+		finish := func(p *FirmwareProgress) {
+			select {
+			case <-ch:
+			default:
+			}
+			ch <- p
+		}
+		fail := func(err error) {
+			finish(&FirmwareProgress{Stage: FirmwareFailed, Err: err})
+		}
+		send := func(p *FirmwareProgress) bool {
+			select {
+			case ch <- p:
+				return true
+			case <-ctx.Done():
+				fail(ctx.Err())
+				return false
+			}
+		}
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		wait := func() bool {
+			select {
+			case <-ticker.C:
+				return true
+			case <-ctx.Done():
+				fail(ctx.Err())
+				return false
+			}
+		}
+		var state *FirmwareDownloadState
+		for {
+			state, err = fw.DownloadState()
+			if err != nil {
+				fail(err)
+				return
+			}
+			if state.Status < 0 {
+				fail(&DeviceError{Target: fw.Target(), Command: "get_download_state", Code: state.Status, Message: "firmware download failed"})
+				return
+			}
+			if !send(&FirmwareProgress{Stage: FirmwareDownloading, Ratio: state.Ratio}) {
+				return
+			}
+			if state.Ratio >= 100 {
+				break
+			}
+			if !wait() {
+				return
+			}
+		}
+		err = fw.Flash()
+		if err != nil {
+			fail(err)
+			return
+		}
+		if !send(&FirmwareProgress{Stage: FirmwareFlashing, Ratio: 100}) {
+			return
+		}
+		// don't bother polling before the device says it could be back
+		select {
+		case <-time.After(time.Duration(state.FlashTime) * time.Second):
+		case <-ctx.Done():
+			fail(ctx.Err())
+			return
+		}
+		if !send(&FirmwareProgress{Stage: FirmwareRebooting, Ratio: 100}) {
+			return
+		}
+		// the device may answer for a while before it goes down, so an
+		// unchanged version only counts once it has had time to reboot
+		rebooted := false
+		var rebootBy time.Time
+		if state.RebootTime > 0 {
+			rebootBy = time.Now().Add(time.Duration(state.RebootTime) * time.Second)
+		}
+		for {
+			if !wait() {
+				return
+			}
+			if dev.Update() != nil {
+				rebooted = true
+				continue
+			}
+			if !rebootBy.IsZero() && time.Now().After(rebootBy) {
+				rebooted = true
+			}
+			sysinfo := dev.GetSysInfo()
+			if sysinfo == nil || sysinfo.Updating != 0 {
+				continue
+			}
+			if rebooted || sysinfo.SoftwareVersion != oldVersion {
+				finish(&FirmwareProgress{Stage: FirmwareDone, Ratio: 100})
+				return
+			}
+		}
+	}()
+	return ch, nil
+}
+
+// FirmwareVersion is a combination of model, hardware and software versions
+// found on a set of devices.
+type FirmwareVersion struct {
+	Model string `json:"model"`
+	HardwareVersion string `json:"hw_ver"`
+	SoftwareVersion string `json:"sw_ver"`
+	Devices []SmartDevice `json:"devices"`
+}
+
+func (v *FirmwareVersion) String() string {
+	return fmt.Sprintf("%s hw %s sw %s: %d devices", v.Model, v.HardwareVersion, v.SoftwareVersion, len(v.Devices))
+}
+
+// FirmwareReport groups devices by model, hardware version and software
+// version, using their cached sysinfo.  Strip sockets are counted as part
+// of their strip.
+func FirmwareReport(devices []SmartDevice) []*FirmwareVersion {
+	versions := map[[3]string]*FirmwareVersion{}
+	for _, dev := range devices {
+		if dev.IsStripSocket() {
+			continue
+		}
+		sysinfo := dev.GetSysInfo()
+		if sysinfo == nil {
+			continue
+		}
+		key := [3]string{sysinfo.Model, sysinfo.HardwareVersion, sysinfo.SoftwareVersion}
+		v, ok := versions[key]
+		if !ok {
+			v = &FirmwareVersion{Model: key[0], HardwareVersion: key[1], SoftwareVersion: key[2]}
+			versions[key] = v
+		}
+		v.Devices = append(v.Devices, dev)
+	}
+	report := make([]*FirmwareVersion, 0, len(versions))
+	for _, v := range versions {
+		report = append(report, v)
+	}
+	sort.Slice(report, func(i, j int) bool {
+		a, b := report[i], report[j]
+		if a.Model != b.Model {
+			return a.Model < b.Model
+		}
+		if a.HardwareVersion != b.HardwareVersion {
+			return a.HardwareVersion < b.HardwareVersion
+		}
+		return a.SoftwareVersion < b.SoftwareVersion
+	})
+	return report
+}
+
+func init() {
+	RegisterModule(&ModuleSpec{
+		Name: FirmwareModule,
+		Target: iotTarget("system", "smartlife.iot.common.system"),
+		New: func(base *ModuleBase) Module {
+			return &Firmware{base}
+		},
+		Shared: true,
+	})
+}
@@ -0,0 +1,104 @@
+package kasa
+
+import (
+	"encoding/json"
+)
+
+// LightStateRequest changes some or all of a light's state.  Nil fields
+// are left as they are.
+type LightStateRequest struct {
+	OnOff *int `json:"on_off,omitempty"`
+	Hue *int `json:"hue,omitempty"`
+	Saturation *int `json:"saturation,omitempty"`
+	ColorTemp *int `json:"color_temp,omitempty"`
+	Brightness *int `json:"brightness,omitempty"`
+	Mode string `json:"mode,omitempty"`
+	TransitionPeriod int `json:"transition_period,omitempty"`
+	IgnoreDefault int `json:"ignore_default"`
+}
+
+type Light struct {
+	*ModuleBase
+}
+
+func (light *Light) State() (*LightState, error) {
+	res := &LightState{}
+	err := light.Query(res, "get_light_state", nil)
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// SetState transitions the light and returns the state it ends up in,
+// which also replaces the light state in the cached sysinfo.
+func (light *Light) SetState(req *LightStateRequest) (*LightState, error) {
+	res := &LightState{}
+	err := light.Query(res, "transition_light_state", req)
+	if err != nil {
+		return nil, err
+	}
+	light.base.patchSysInfo(func(sysinfo *SysInfo) {
+		state := *res
+		sysinfo.LightState = &state
+	})
+	return res, nil
+}
+
+type LightingEffectState struct {
+	Enable int `json:"enable"`
+	Name string `json:"name,omitempty"`
+	ID string `json:"id,omitempty"`
+	Brightness int `json:"brightness,omitempty"`
+	Custom int `json:"custom,omitempty"`
+}
+
+type LightEffect struct {
+	*ModuleBase
+}
+
+// State returns the effect state the device last reported in its
+// sysinfo.
+func (le *LightEffect) State() (*LightingEffectState, error) {
+	state := &LightingEffectState{}
+	_, err := le.Device().GetSysInfo().DecodeRaw("lighting_effect_state", state)
+	if err != nil {
+		return nil, err
+	}
+	return state, nil
+}
+
+// SetEffect starts an effect.  The effect definition is passed to the
+// device as is.
+func (le *LightEffect) SetEffect(effect json.RawMessage) error {
+	return le.Query(nil, "set_lighting_effect", effect)
+}
+
+func (le *LightEffect) Disable() error {
+	return le.Query(nil, "set_lighting_effect", map[string]interface{}{"enable": 0})
+}
+
+func init() {
+	RegisterModule(&ModuleSpec{
+		Name: LightModule,
+		Target: func(dev SmartDevice) string {
+			return dev.GetLightService()
+		},
+		Detect: isBulbFamily,
+		New: func(base *ModuleBase) Module {
+			return &Light{base}
+		},
+	})
+	RegisterModule(&ModuleSpec{
+		Name: LightEffectModule,
+		Target: func(dev SmartDevice) string {
+			return "smartlife.iot.lighting_effect"
+		},
+		Detect: func(dev SmartDevice) bool {
+			return dev.GetSysInfo().Raw("lighting_effect_state") != nil
+		},
+		New: func(base *ModuleBase) Module {
+			return &LightEffect{base}
+		},
+	})
+}
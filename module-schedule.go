@@ -0,0 +1,210 @@
+package kasa
+
+import (
+	"time"
+)
+
+const (
+	RuleTimeClock = 0
+	RuleTimeSunrise = 1
+	RuleTimeSunset = 2
+	RuleTimeNone = -1
+)
+
+const (
+	RuleActionOff = 0
+	RuleActionOn = 1
+	RuleActionNone = -1
+)
+
+// Rule is a schedule or anti-theft rule.  Start and end times are
+// minutes after midnight, or offsets from sunrise or sunset depending on
+// StartOpt and EndOpt.  WeekDays has one entry per day starting with
+// Sunday, 1 if the rule runs that day.
+type Rule struct {
+	ID string `json:"id,omitempty"`
+	Name string `json:"name"`
+	Enable int `json:"enable"`
+	WeekDays []int `json:"wday"`
+	StartOpt int `json:"stime_opt"`
+	StartMinute int `json:"smin"`
+	StartAction int `json:"sact"`
+	EndOpt int `json:"etime_opt"`
+	EndMinute int `json:"emin"`
+	EndAction int `json:"eact"`
+	Repeat int `json:"repeat"`
+	Year int `json:"year"`
+	Month int `json:"month"`
+	Day int `json:"day"`
+	Frequency int `json:"frequency,omitempty"`
+	Light *LightState `json:"s_light,omitempty"`
+}
+
+// NewDailyRule returns an enabled rule that runs at the given time of day
+// on the given days of the week (every day if none are given), turning
+// the device on or off.
+func NewDailyRule(name string, at time.Duration, on bool, days ...time.Weekday) *Rule {
+	rule := &Rule{
+		Name: name,
+		Enable: 1,
+		WeekDays: make([]int, 7),
+		StartOpt: RuleTimeClock,
+		StartMinute: int(at.Minutes()),
+		StartAction: RuleActionOff,
+		EndOpt: RuleTimeNone,
+		EndAction: RuleActionNone,
+		Repeat: 1,
+	}
+	if on {
+		rule.StartAction = RuleActionOn
+	}
+	if len(days) == 0 {
+		days = []time.Weekday{time.Sunday, time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday, time.Saturday}
+	}
+	for _, day := range days {
+		rule.WeekDays[day] = 1
+	}
+	return rule
+}
+
+type RuleList struct {
+	Rules []*Rule `json:"rule_list"`
+	Enable int `json:"enable"`
+	Version int `json:"version,omitempty"`
+}
+
+// ruleModule implements the commands schedule and anti-theft rules share.
+type ruleModule struct {
+	*ModuleBase
+}
+
+func (m *ruleModule) Rules() (*RuleList, error) {
+	res := &RuleList{}
+	err := m.Query(res, "get_rules", nil)
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// AddRule adds a rule and returns the ID the device assigned it.
+func (m *ruleModule) AddRule(rule *Rule) (string, error) {
+	res := &struct{
+		ID string `json:"id"`
+	}{}
+	xrule := *rule
+	xrule.ID = ""
+	err := m.Query(res, "add_rule", &xrule)
+	if err != nil {
+		return "", err
+	}
+	return res.ID, nil
+}
+
+func (m *ruleModule) EditRule(rule *Rule) error {
+	return m.Query(nil, "edit_rule", rule)
+}
+
+func (m *ruleModule) DeleteRule(id string) error {
+	return m.Query(nil, "delete_rule", map[string]interface{}{"id": id})
+}
+
+func (m *ruleModule) DeleteAllRules() error {
+	return m.Query(nil, "delete_all_rules", nil)
+}
+
+// SetEnabled turns all of the module's rules on or off at once.
+func (m *ruleModule) SetEnabled(enable bool) error {
+	state := 0
+	if enable {
+		state = 1
+	}
+	return m.Query(nil, "set_overall_enable", map[string]interface{}{"enable": state})
+}
+
+type Schedule struct {
+	ruleModule
+}
+
+type AntiTheft struct {
+	ruleModule
+}
+
+// CountdownRule switches the device on or off once, Delay seconds after
+// it is enabled.
+type CountdownRule struct {
+	ID string `json:"id,omitempty"`
+	Name string `json:"name"`
+	Enable int `json:"enable"`
+	Delay int `json:"delay"`
+	Action int `json:"act"`
+	Remaining int `json:"remain,omitempty"`
+}
+
+type Countdown struct {
+	*ModuleBase
+}
+
+func (cd *Countdown) Rules() ([]*CountdownRule, error) {
+	res := &struct{
+		Rules []*CountdownRule `json:"rule_list"`
+	}{}
+	err := cd.Query(res, "get_rules", nil)
+	if err != nil {
+		return nil, err
+	}
+	return res.Rules, nil
+}
+
+// Start replaces any existing countdown with one that turns the device on
+// or off after delay.  Devices only support a single countdown rule.
+func (cd *Countdown) Start(delay time.Duration, on bool) error {
+	err := cd.DeleteAll()
+	if err != nil {
+		return err
+	}
+	rule := &CountdownRule{
+		Name: "countdown",
+		Enable: 1,
+		Delay: int(delay.Seconds()),
+		Action: RuleActionOff,
+	}
+	if on {
+		rule.Action = RuleActionOn
+	}
+	return cd.Query(nil, "add_rule", rule)
+}
+
+func (cd *Countdown) DeleteAll() error {
+	return cd.Query(nil, "delete_all_rules", nil)
+}
+
+func init() {
+	RegisterModule(&ModuleSpec{
+		Name: ScheduleModule,
+		Target: iotTarget("schedule", "smartlife.iot.common.schedule"),
+		New: func(base *ModuleBase) Module {
+			return &Schedule{ruleModule{base}}
+		},
+	})
+	RegisterModule(&ModuleSpec{
+		Name: AntiTheftModule,
+		Target: iotTarget("anti_theft", "smartlife.iot.common.anti_theft"),
+		Detect: func(dev SmartDevice) bool {
+			return !isBulbFamily(dev)
+		},
+		New: func(base *ModuleBase) Module {
+			return &AntiTheft{ruleModule{base}}
+		},
+	})
+	RegisterModule(&ModuleSpec{
+		Name: CountdownModule,
+		Target: iotTarget("count_down", "smartlife.iot.common.count_down"),
+		Detect: func(dev SmartDevice) bool {
+			return !isBulbFamily(dev)
+		},
+		New: func(base *ModuleBase) Module {
+			return &Countdown{base}
+		},
+	})
+}
@@ -0,0 +1,50 @@
+package kasa
+
+import (
+	"time"
+)
+
+type Time struct {
+	*ModuleBase
+}
+
+func (tm *Time) Timezone() (Timezone, error) {
+	res := &TimeZoneInfo{}
+	err := tm.Query(res, "get_timezone", nil)
+	if err != nil {
+		return 0, err
+	}
+	return res.Timezone, nil
+}
+
+// Time returns the device's clock in its configured timezone.
+func (tm *Time) Time() (time.Time, error) {
+	tz, err := tm.Timezone()
+	if err != nil {
+		return time.Time{}, err
+	}
+	res := &TimeInfo{}
+	err = tm.Query(res, "get_time", nil)
+	if err != nil {
+		return time.Time{}, err
+	}
+	resp := &TimeInfoResponse{TimeInfo: res, TimeZone: &TimeZoneInfo{Timezone: tz}}
+	return resp.Date(), nil
+}
+
+func (tm *Time) SetTimezone(tz Timezone) error {
+	return tm.Query(nil, "set_timezone", map[string]interface{}{"index": int(tz)})
+}
+
+func init() {
+	RegisterModule(&ModuleSpec{
+		Name: TimeModule,
+		Target: func(dev SmartDevice) string {
+			return dev.GetTimeService()
+		},
+		New: func(base *ModuleBase) Module {
+			return &Time{base}
+		},
+		Shared: true,
+	})
+}
@@ -0,0 +1,137 @@
+package kasa
+
+import (
+	"errors"
+	"time"
+)
+
+type UsageDayStat struct {
+	Year int `json:"year"`
+	Month time.Month `json:"month"`
+	Day int `json:"day"`
+	Minutes int `json:"time"`
+}
+
+func (stat *UsageDayStat) Duration() time.Duration {
+	return time.Duration(stat.Minutes) * time.Minute
+}
+
+func (stat *UsageDayStat) Date(loc *time.Location) time.Time {
+	return time.Date(stat.Year, stat.Month, stat.Day, 0, 0, 0, 0, loc)
+}
+
+type UsageMonthStat struct {
+	Year int `json:"year"`
+	Month time.Month `json:"month"`
+	Minutes int `json:"time"`
+}
+
+func (stat *UsageMonthStat) Duration() time.Duration {
+	return time.Duration(stat.Minutes) * time.Minute
+}
+
+// UsageSummary is how long a device has been on today, since the start of
+// the week (Sunday), and since the start of the month.
+type UsageSummary struct {
+	Today time.Duration `json:"today"`
+	ThisWeek time.Duration `json:"this_week"`
+	ThisMonth time.Duration `json:"this_month"`
+}
+
+// Usage reads the on-time counters devices keep alongside their
+// schedules.  Unlike the emeter, every device has them.
+type Usage struct {
+	*ModuleBase
+}
+
+func (u *Usage) DayStats(year int, month time.Month) ([]*UsageDayStat, error) {
+	res := &struct{
+		Days []*UsageDayStat `json:"day_list"`
+	}{}
+	err := u.Query(res, "get_daystat", map[string]interface{}{"year": year, "month": int(month)})
+	if err != nil {
+		return nil, err
+	}
+	return res.Days, nil
+}
+
+func (u *Usage) MonthStats(year int) ([]*UsageMonthStat, error) {
+	res := &struct{
+		Months []*UsageMonthStat `json:"month_list"`
+	}{}
+	err := u.Query(res, "get_monthstat", map[string]interface{}{"year": year})
+	if err != nil {
+		return nil, err
+	}
+	return res.Months, nil
+}
+
+// Erase clears the stored on-time statistics.
+func (u *Usage) Erase() error {
+	return u.Query(nil, "erase_runtime_stat", nil)
+}
+
+// Summary totals the daily statistics for the day, week and month that
+// now falls in.  Pass the time in the device's timezone, since that's
+// what its days are counted in.
+func (u *Usage) Summary(now time.Time) (*UsageSummary, error) {
+	loc := now.Location()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, loc)
+	weekStart := today.AddDate(0, 0, -int(today.Weekday()))
+	days, err := u.DayStats(today.Year(), today.Month())
+	if err != nil {
+		return nil, err
+	}
+	if weekStart.Month() != today.Month() {
+		// the week started last month
+		prev, err := u.DayStats(weekStart.Year(), weekStart.Month())
+		if err != nil {
+			return nil, err
+		}
+		days = append(prev, days...)
+	}
+	summary := &UsageSummary{}
+	for _, day := range days {
+		date := day.Date(loc)
+		if date.After(today) {
+			continue
+		}
+		if date.Equal(today) {
+			summary.Today += day.Duration()
+		}
+		if !date.Before(weekStart) {
+			summary.ThisWeek += day.Duration()
+		}
+		if date.Year() == today.Year() && date.Month() == today.Month() {
+			summary.ThisMonth += day.Duration()
+		}
+	}
+	return summary, nil
+}
+
+// UsageSummaryFor returns how long dev has been on today, this week and
+// this month, by the device's own clock.
+func UsageSummaryFor(dev SmartDevice) (*UsageSummary, error) {
+	u, ok := dev.Module(UsageModule).(*Usage)
+	if !ok {
+		return nil, errors.New("device has no usage statistics")
+	}
+	now := time.Now()
+	if tm, ok := dev.Module(TimeModule).(*Time); ok {
+		devNow, err := tm.Time()
+		if err == nil {
+			now = devNow
+		}
+	}
+	return u.Summary(now)
+}
+
+func init() {
+	RegisterModule(&ModuleSpec{
+		Name: UsageModule,
+		Target: iotTarget("schedule", "smartlife.iot.common.schedule"),
+		New: func(base *ModuleBase) Module {
+			return &Usage{base}
+		},
+	})
+}
@@ -0,0 +1,242 @@
+package kasa
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+type ModuleName string
+
+const (
+	EmeterModule = ModuleName("emeter")
+	ScheduleModule = ModuleName("schedule")
+	CountdownModule = ModuleName("countdown")
+	AntiTheftModule = ModuleName("antitheft")
+	TimeModule = ModuleName("time")
+	CloudModule = ModuleName("cloud")
+	FirmwareModule = ModuleName("firmware")
+	UsageModule = ModuleName("usage")
+	LightModule = ModuleName("light")
+	LightEffectModule = ModuleName("lighteffect")
+)
+
+// Module is a group of related commands a device supports, such as energy
+// metering or schedules, talking to one service on the device.
+type Module interface {
+	Name() ModuleName
+	Target() string
+	Device() SmartDevice
+}
+
+// ModuleSpec describes a module so it can be detected on and attached to
+// devices.  Register new ones with RegisterModule.
+type ModuleSpec struct {
+	Name ModuleName
+	// Target returns the service the module talks to on dev, which often
+	// differs between plugs and bulbs.
+	Target func(dev SmartDevice) string
+	// Detect reports whether dev has the module.  Nil means every device
+	// does.
+	Detect func(dev SmartDevice) bool
+	// New wraps a ModuleBase in the module's concrete type.
+	New func(base *ModuleBase) Module
+	// Shared modules belong to the whole device.  On a strip socket they
+	// talk to the strip itself rather than to the socket.
+	Shared bool
+}
+
+// ErrNoModule is returned when a device doesn't have the module an
+// operation needs.
+var ErrNoModule = errors.New("module not supported")
+
+// errNoModule explains why dev has no module called name.  Devices that
+// need a transport this package doesn't speak have no modules at all.
+func errNoModule(dev SmartDevice, name ModuleName) error {
+	if xdev, ok := dev.(*EncryptedDevice); ok {
+		return fmt.Errorf("%w: %s requires %s", ErrUnsupportedTransport, dev.IP(), xdev.RequiredTransport())
+	}
+	return fmt.Errorf("%w: %s on %s", ErrNoModule, name, dev.IP())
+}
+
+var moduleSpecs = map[ModuleName]*ModuleSpec{}
+var moduleSpecsLock sync.RWMutex
+
+// RegisterModule adds a module to the set detected on every device,
+// replacing any module with the same name.
+func RegisterModule(spec *ModuleSpec) {
+	moduleSpecsLock.Lock()
+	defer moduleSpecsLock.Unlock()
+	moduleSpecs[spec.Name] = spec
+}
+
+func lookupModuleSpec(name ModuleName) *ModuleSpec {
+	moduleSpecsLock.RLock()
+	defer moduleSpecsLock.RUnlock()
+	return moduleSpecs[name]
+}
+
+func listModuleSpecs() []*ModuleSpec {
+	moduleSpecsLock.RLock()
+	defer moduleSpecsLock.RUnlock()
+	specs := make([]*ModuleSpec, 0, len(moduleSpecs))
+	for _, spec := range moduleSpecs {
+		specs = append(specs, spec)
+	}
+	sort.Slice(specs, func(i, j int) bool {
+		return specs[i].Name < specs[j].Name
+	})
+	return specs
+}
+
+// DeviceError is an error reported by the device itself, as opposed to a
+// failure to reach it.
+type DeviceError struct {
+	Target string
+	Command string
+	Code int
+	Message string
+}
+
+func (derr *DeviceError) Error() string {
+	if derr.Message != "" {
+		return fmt.Sprintf("%s.%s failed: %s (%d)", derr.Target, derr.Command, derr.Message, derr.Code)
+	}
+	return fmt.Sprintf("%s.%s failed with error code %d", derr.Target, derr.Command, derr.Code)
+}
+
+type errorStatus struct {
+	ErrorCode int `json:"err_code"`
+	ErrorMessage string `json:"err_msg,omitempty"`
+}
+
+// ModuleBase implements the plumbing shared by all modules.  Concrete
+// modules embed it.
+type ModuleBase struct {
+	name ModuleName
+	target string
+	dev SmartDevice
+	base *BaseDevice
+	childIDs []interface{}
+}
+
+func (m *ModuleBase) Name() ModuleName {
+	return m.name
+}
+
+func (m *ModuleBase) Target() string {
+	return m.target
+}
+
+func (m *ModuleBase) Device() SmartDevice {
+	return m.dev
+}
+
+// Query sends cmd to the module's service and decodes the command's
+// response into res, which may be nil.  Errors reported by the device
+// are returned as *DeviceError.
+func (m *ModuleBase) Query(res interface{}, cmd string, arg interface{}) error {
+	envelope := map[string]map[string]json.RawMessage{}
+	err := m.base.Query(&envelope, m.target, cmd, arg, m.childIDs...)
+	if err != nil {
+		return err
+	}
+	service, ok := envelope[m.target]
+	if !ok {
+		return &DeviceError{Target: m.target, Command: cmd, Code: -1, Message: "no response"}
+	}
+	status := &errorStatus{}
+	if data, ok := service["err_code"]; ok {
+		// the whole service failed, e.g. "module not support"
+		json.Unmarshal(data, &status.ErrorCode)
+		json.Unmarshal(service["err_msg"], &status.ErrorMessage)
+		return &DeviceError{Target: m.target, Command: cmd, Code: status.ErrorCode, Message: status.ErrorMessage}
+	}
+	data, ok := service[cmd]
+	if !ok {
+		return &DeviceError{Target: m.target, Command: cmd, Code: -1, Message: "no response"}
+	}
+	json.Unmarshal(data, status)
+	if status.ErrorCode != 0 {
+		return &DeviceError{Target: m.target, Command: cmd, Code: status.ErrorCode, Message: status.ErrorMessage}
+	}
+	if res == nil {
+		return nil
+	}
+	return json.Unmarshal(data, res)
+}
+
+func newModule(spec *ModuleSpec, dev SmartDevice, base *BaseDevice, childIDs ...interface{}) Module {
+	if dev.DeviceType() == DeviceTypeEncrypted {
+		// none of these services are reachable over the legacy protocol
+		return nil
+	}
+	if spec.Detect != nil && !spec.Detect(dev) {
+		return nil
+	}
+	mb := &ModuleBase{
+		name: spec.Name,
+		target: spec.Target(dev),
+		dev: dev,
+		base: base,
+	}
+	if !spec.Shared {
+		mb.childIDs = childIDs
+	}
+	return spec.New(mb)
+}
+
+func (dev *BaseDevice) concrete() SmartDevice {
+	if dev.self == nil {
+		return dev
+	}
+	return dev.self
+}
+
+// Module returns the named module if the device supports it, or nil.
+func (dev *BaseDevice) Module(name ModuleName) Module {
+	spec := lookupModuleSpec(name)
+	if spec == nil {
+		return nil
+	}
+	return newModule(spec, dev.concrete(), dev)
+}
+
+// Modules returns every module the device supports, sorted by name.
+func (dev *BaseDevice) Modules() []Module {
+	modules := []Module{}
+	for _, spec := range listModuleSpecs() {
+		if m := newModule(spec, dev.concrete(), dev); m != nil {
+			modules = append(modules, m)
+		}
+	}
+	return modules
+}
+
+// GetModule returns the first module of type T the device supports, e.g.
+// GetModule[*Emeter](dev).
+func GetModule[T Module](dev SmartDevice) (T, bool) {
+	for _, m := range dev.Modules() {
+		if xm, ok := m.(T); ok {
+			return xm, true
+		}
+	}
+	var zero T
+	return zero, false
+}
+
+func isBulbFamily(dev SmartDevice) bool {
+	return dev.IsBulb() || dev.IsLightStrip()
+}
+
+// iotTarget picks between the plug and bulb flavors of a service name.
+func iotTarget(plug, bulb string) func(SmartDevice) string {
+	return func(dev SmartDevice) string {
+		if isBulbFamily(dev) {
+			return bulb
+		}
+		return plug
+	}
+}
@@ -0,0 +1,139 @@
+package kasa
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestModuleResolution(t *testing.T) {
+	strip := jsonTestDevice(t, `{"model":"HS300(US)","deviceId":"STRIP","children":[{"id":"00"},{"id":"01"}]}`).AsConcrete().(*SmartStrip)
+	res, err := parseDiscoveryResponse(discoveryReply("AES"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	plugModules := map[ModuleName]string{
+		AntiTheftModule: "anti_theft",
+		CloudModule: "cnCloud",
+		CountdownModule: "count_down",
+		FirmwareModule: "system",
+		ScheduleModule: "schedule",
+		TimeModule: "time",
+		UsageModule: "schedule",
+	}
+	with := func(modules map[ModuleName]string, name ModuleName, target string) map[ModuleName]string {
+		xmodules := map[ModuleName]string{name: target}
+		for k, v := range modules {
+			xmodules[k] = v
+		}
+		return xmodules
+	}
+	bulbModules := map[ModuleName]string{
+		CloudModule: "smartlife.iot.common.cloud",
+		EmeterModule: "smartlife.iot.common.emeter",
+		FirmwareModule: "smartlife.iot.common.system",
+		ScheduleModule: "smartlife.iot.common.schedule",
+		TimeModule: "smartlife.iot.common.timesetting",
+		UsageModule: "smartlife.iot.common.schedule",
+	}
+	tests := []struct {
+		name string
+		dev SmartDevice
+		modules map[ModuleName]string
+	}{
+		{"plug", jsonTestDevice(t, `{"model":"HS100(US)"}`).AsConcrete(), plugModules},
+		{"plug with emeter", jsonTestDevice(t, `{"model":"HS110(US)"}`).AsConcrete(), with(plugModules, EmeterModule, "emeter")},
+		{"dimmer", jsonTestDevice(t, `{"model":"HS220(US)"}`).AsConcrete(), plugModules},
+		{"strip", strip, with(plugModules, EmeterModule, "emeter")},
+		{"strip socket", strip.Children()[1], with(plugModules, EmeterModule, "emeter")},
+		{"bulb", jsonTestDevice(t, `{"model":"KL130(US)"}`).AsConcrete(), with(bulbModules, LightModule, "smartlife.iot.smartbulb.lightingservice")},
+		{"light strip", jsonTestDevice(t, `{"model":"KL430(US)"}`).AsConcrete(), with(bulbModules, LightModule, "smartlife.iot.lightStrip")},
+		{"light strip with effects", jsonTestDevice(t, `{"model":"KL430(US)","lighting_effect_state":{"enable":0}}`).AsConcrete(), with(with(bulbModules, LightModule, "smartlife.iot.lightStrip"), LightEffectModule, "smartlife.iot.lighting_effect")},
+		{"encrypted", newEncryptedDevice("192.0.2.11", res), map[ModuleName]string{}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got := map[ModuleName]string{}
+			names := []string{}
+			for _, m := range tc.dev.Modules() {
+				got[m.Name()] = m.Target()
+				names = append(names, string(m.Name()))
+				if m.Device() != tc.dev {
+					t.Errorf("%s module belongs to %v", m.Name(), m.Device())
+				}
+			}
+			if !reflect.DeepEqual(got, tc.modules) {
+				t.Errorf("modules = %v, want %v", got, tc.modules)
+			}
+			if !sort.StringsAreSorted(names) {
+				t.Errorf("modules not sorted by name: %v", names)
+			}
+			for name := range tc.modules {
+				if m := tc.dev.Module(name); m == nil || m.Name() != name {
+					t.Errorf("Module(%s) = %v", name, m)
+				}
+			}
+			if m := tc.dev.Module(ModuleName("nonexistent")); m != nil {
+				t.Errorf("Module(nonexistent) = %v", m)
+			}
+		})
+	}
+}
+
+func TestStripSocketModuleContext(t *testing.T) {
+	strip := jsonTestDevice(t, `{"model":"HS300(US)","deviceId":"STRIP","children":[{"id":"00"},{"id":"01"}]}`).AsConcrete().(*SmartStrip)
+	var sent map[string]interface{}
+	strip.SetRetryPolicy(NoRetry)
+	strip.SetTransport(funcTransport(func(host string, req interface{}, dst interface{}) error {
+		data, _ := json.Marshal(req)
+		sent = map[string]interface{}{}
+		json.Unmarshal(data, &sent)
+		return json.Unmarshal([]byte(`{"emeter":{"get_realtime":{"err_code":0}},"system":{"get_download_state":{"err_code":0}}}`), dst)
+	}))
+	// sockets are addressed by the strip's ID plus their own, the same as
+	// their relay commands
+	socket := strip.Children()[1]
+	em := socket.Module(EmeterModule).(*Emeter)
+	em.Query(nil, "get_realtime", nil)
+	want := map[string]interface{}{"child_ids": []interface{}{"STRIP01"}}
+	if !reflect.DeepEqual(sent["context"], want) {
+		t.Errorf("socket emeter query context = %v, want %v", sent["context"], want)
+	}
+	// shared modules talk to the strip itself
+	fw := socket.Module(FirmwareModule).(*Firmware)
+	fw.DownloadState()
+	if ctx, ok := sent["context"]; ok {
+		t.Errorf("socket firmware query sent context %v", ctx)
+	}
+}
+
+func TestAuditCloudMissingModule(t *testing.T) {
+	// pretend one model has no cloud service
+	spec := lookupModuleSpec(CloudModule)
+	defer RegisterModule(spec)
+	xspec := *spec
+	xspec.Detect = func(dev SmartDevice) bool {
+		return dev.GetSysInfo().Model != "HS105(US)"
+	}
+	RegisterModule(&xspec)
+	res, err := parseDiscoveryResponse(discoveryReply("AES"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	devices := []SmartDevice{
+		jsonTestDevice(t, `{"model":"HS105(US)","deviceId":"PLUG"}`).AsConcrete(),
+		newEncryptedDevice("192.0.2.11", res),
+	}
+	statuses := AuditCloud(devices)
+	if len(statuses) != 2 {
+		t.Fatalf("%d statuses, want 2", len(statuses))
+	}
+	if err := statuses[0].Err; !errors.Is(err, ErrNoModule) || errors.Is(err, ErrUnsupportedTransport) {
+		t.Errorf("device without cloud module: %v", err)
+	}
+	if err := statuses[1].Err; !errors.Is(err, ErrUnsupportedTransport) {
+		t.Errorf("encrypted device: %v", err)
+	}
+}
@@ -0,0 +1,145 @@
+package kasa
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DEFAULT_ONBOARD_TIMEOUT is how long Onboard waits for a device to show
+// up on its new network.
+const DEFAULT_ONBOARD_TIMEOUT = 2 * time.Minute
+
+// FACTORY_ADDR is where a factory-reset device answers on its own access
+// point.
+const FACTORY_ADDR = "192.168.0.1"
+
+var ErrNetworkNotFound = errors.New("wireless network not found")
+
+// Sorted returns the access points sorted by SSID, without duplicates or
+// hidden networks.
+func (info *WifiScanInfo) Sorted() []*WifiAP {
+	seen := map[string]bool{}
+	aps := []*WifiAP{}
+	for _, ap := range info.AccessPoints {
+		if ap.SSID == "" || seen[ap.SSID] {
+			continue
+		}
+		seen[ap.SSID] = true
+		aps = append(aps, ap)
+	}
+	sort.Slice(aps, func(i, j int) bool {
+		return strings.ToLower(aps[i].SSID) < strings.ToLower(aps[j].SSID)
+	})
+	return aps
+}
+
+// Filter returns the sorted access points for which match returns true.
+func (info *WifiScanInfo) Filter(match func(*WifiAP) bool) []*WifiAP {
+	aps := []*WifiAP{}
+	for _, ap := range info.Sorted() {
+		if match(ap) {
+			aps = append(aps, ap)
+		}
+	}
+	return aps
+}
+
+// Secured returns the sorted access points that use WPA or WPA2.
+func (info *WifiScanInfo) Secured() []*WifiAP {
+	return info.Filter(func(ap *WifiAP) bool {
+		return ap.KeyType == WifiKeyWPA || ap.KeyType == WifiKeyWPA2
+	})
+}
+
+// Find returns the access point with the given SSID, or nil.
+func (info *WifiScanInfo) Find(ssid string) *WifiAP {
+	for _, ap := range info.AccessPoints {
+		if ap.SSID == ssid {
+			return ap
+		}
+	}
+	return nil
+}
+
+type OnboardOptions struct {
+	// Timeout is how long to wait for the device on its new network,
+	// DEFAULT_ONBOARD_TIMEOUT if zero.
+	Timeout time.Duration
+	// Hidden skips checking that the device can see the network, using
+	// KeyType instead of the key type the scan reports.  KeyType defaults
+	// to WifiKeyWPA2 for hidden networks.
+	Hidden bool
+	KeyType WifiKeyType
+	// Discover controls how the device is looked for on the new network.
+	// Since the host is usually still on the device's access point when
+	// Onboard starts, this typically names the interface on the target
+	// network.
+	Discover *DiscoverOptions
+}
+
+// Onboard moves dev onto the given wireless network and waits for it to
+// reappear there, found by MAC address with discovery.  It returns the
+// device at its new address.
+func Onboard(ctx context.Context, dev SmartDevice, ssid, password string, opts *OnboardOptions) (SmartDevice, error) {
+	if opts == nil {
+		opts = &OnboardOptions{}
+	}
+	mac := dev.MAC()
+	if mac == "" {
+		err := dev.Update()
+		if err != nil {
+			return nil, err
+		}
+		mac = dev.MAC()
+	}
+	if mac == "" {
+		return nil, errors.New("device did not report a MAC address")
+	}
+	keytype := opts.KeyType
+	if opts.Hidden && keytype == WifiKeyNone {
+		keytype = WifiKeyWPA2
+	}
+	if !opts.Hidden {
+		scan, err := dev.WifiScan()
+		if err != nil {
+			return nil, err
+		}
+		ap := scan.Find(ssid)
+		if ap == nil {
+			return nil, fmt.Errorf("%w: %s", ErrNetworkNotFound, ssid)
+		}
+		keytype = ap.KeyType
+	}
+	err := dev.WifiJoin(ssid, password, keytype)
+	if err != nil {
+		if !IsNetError(err) {
+			return nil, err
+		}
+		// most likely the device left its access point before answering;
+		// the discovery below will tell
+		if Debug {
+			log.Printf("%s dropped off while joining %s: %s", mac, ssid, err)
+		}
+	}
+	return WaitForDevice(ctx, mac, opts.Timeout, opts.Discover)
+}
+
+// WaitForDevice looks for the device with the given MAC address until it
+// turns up or timeout elapses.
+func WaitForDevice(ctx context.Context, mac string, timeout time.Duration, opts *DiscoverOptions) (SmartDevice, error) {
+	if timeout <= 0 {
+		timeout = DEFAULT_ONBOARD_TIMEOUT
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	dev, err := FindDevice(ctx, MatchMAC(mac), opts)
+	if err != nil {
+		return nil, fmt.Errorf("%s did not appear: %w", mac, err)
+	}
+	return dev, nil
+}
@@ -0,0 +1,120 @@
+package kasa
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+const onboardMAC = "AA:BB:CC:DD:EE:01"
+
+// serveDiscovery answers every legacy discovery probe sent to the
+// returned address with a plug reporting onboardMAC.
+func serveDiscovery(t *testing.T) string {
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	reply := encrypt([]byte(`{"system":{"get_sysinfo":{"alias":"plug","mic_type":"IOT.SMARTPLUGSWITCH","deviceId":"ID1","mac":"` + onboardMAC + `"}}}`))
+	go func() {
+		buf := make([]byte, 1024)
+		for {
+			_, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			conn.WriteTo(reply, addr)
+		}
+	}()
+	return conn.LocalAddr().String()
+}
+
+func TestOnboard(t *testing.T) {
+	scan := `{"ap_list":[{"ssid":"home","key_type":2},{"ssid":"guest","key_type":0}],"err_code":0}`
+	tests := []struct {
+		name string
+		ssid string
+		opts OnboardOptions
+		// join is what set_stainfo does: "ok", "drop" or "reject"
+		join string
+		scanned bool
+		keytype WifiKeyType
+		err error
+	}{
+		{"key type from scan", "home", OnboardOptions{}, "ok", true, WifiKeyWPA, nil},
+		{"open network", "guest", OnboardOptions{}, "ok", true, WifiKeyNone, nil},
+		{"scan overrides key type", "home", OnboardOptions{KeyType: WifiKeyWEP}, "ok", true, WifiKeyWPA, nil},
+		{"hidden defaults to WPA2", "attic", OnboardOptions{Hidden: true}, "ok", false, WifiKeyWPA2, nil},
+		{"hidden with key type", "attic", OnboardOptions{Hidden: true, KeyType: WifiKeyWEP}, "ok", false, WifiKeyWEP, nil},
+		{"network not found", "attic", OnboardOptions{}, "", true, WifiKeyNone, ErrNetworkNotFound},
+		{"dropped off while joining", "home", OnboardOptions{}, "drop", true, WifiKeyWPA, nil},
+		{"join rejected", "home", OnboardOptions{}, "reject", true, WifiKeyWPA, &DeviceError{}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			sysinfo := &SysInfo{DeviceID: "ID1", MACAddr: onboardMAC, Alias: "plug", MicType: "IOT.SMARTPLUGSWITCH"}
+			dev := &BaseDevice{Addr: FACTORY_ADDR, Info: &Query{System: &SysInfoResponse{SysInfo: sysinfo}}}
+			dev.SetRetryPolicy(NoRetry)
+			scanned := false
+			var joined *WifiAP
+			dev.SetTransport(funcTransport(func(host string, req interface{}, dst interface{}) error {
+				data, _ := json.Marshal(req)
+				xreq := map[string]map[string]json.RawMessage{}
+				json.Unmarshal(data, &xreq)
+				res := `{"netif":{"err_code":-1,"err_msg":"module not support"}}`
+				if _, ok := xreq["netif"]["get_scaninfo"]; ok {
+					scanned = true
+					res = `{"netif":{"get_scaninfo":` + scan + `}}`
+				}
+				if arg, ok := xreq["netif"]["set_stainfo"]; ok {
+					joined = &WifiAP{}
+					json.Unmarshal(arg, joined)
+					switch tc.join {
+					case "drop":
+						return &netError{errors.New("connection reset by peer")}
+					case "reject":
+						res = `{"netif":{"set_stainfo":{"err_code":-2,"err_msg":"wrong password"}}}`
+					default:
+						res = `{"netif":{"set_stainfo":{"err_code":0}}}`
+					}
+				}
+				return json.Unmarshal([]byte(res), dst)
+			}))
+			opts := tc.opts
+			opts.Timeout = 2 * time.Second
+			opts.Discover = &DiscoverOptions{BroadcastAddrs: []string{serveDiscovery(t)}, LegacyOnly: true}
+			found, err := Onboard(context.Background(), dev.AsConcrete(), tc.ssid, "secret", &opts)
+			if scanned != tc.scanned {
+				t.Errorf("scanned = %t, want %t", scanned, tc.scanned)
+			}
+			if tc.join == "" {
+				if joined != nil {
+					t.Errorf("joined %s, want no join", joined.SSID)
+				}
+			} else if joined == nil {
+				t.Fatal("never joined")
+			} else if joined.SSID != tc.ssid || joined.Password != "secret" || joined.KeyType != tc.keytype {
+				t.Errorf("joined %s/%s with %s, want %s/secret with %s", joined.SSID, joined.Password, joined.KeyType, tc.ssid, tc.keytype)
+			}
+			if tc.err != nil {
+				var derr *DeviceError
+				if _, ok := tc.err.(*DeviceError); ok && !errors.As(err, &derr) {
+					t.Errorf("error = %v, want a DeviceError", err)
+				} else if !ok && !errors.Is(err, tc.err) {
+					t.Errorf("error = %v, want %v", err, tc.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if found.MAC() != onboardMAC || found.IP() != "127.0.0.1" {
+				t.Errorf("found %s at %s, want %s at 127.0.0.1", found.MAC(), found.IP(), onboardMAC)
+			}
+		})
+	}
+}
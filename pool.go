@@ -0,0 +1,169 @@
+package kasa
+
+import (
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"syscall"
+	"time"
+)
+
+var ErrPoolClosed = errors.New("connection pool closed")
+
+// ConnPool is a Transport that keeps one TCP connection open per device
+// address and reuses it across requests.  Requests to the same device are
+// serialized on that connection; requests to different devices run
+// concurrently.  Connections that sit idle longer than IdleTimeout are
+// closed.
+type ConnPool struct {
+	IdleTimeout time.Duration
+	lock sync.Mutex
+	conns map[string]*pooledConn
+	closed bool
+	stop chan struct{}
+}
+
+type pooledConn struct {
+	lock sync.Mutex
+	conn net.Conn
+	lastUsed time.Time
+}
+
+func NewConnPool(idleTimeout time.Duration) *ConnPool {
+	pool := &ConnPool{
+		IdleTimeout: idleTimeout,
+		conns: map[string]*pooledConn{},
+		stop: make(chan struct{}),
+	}
+	if idleTimeout > 0 {
+		go pool.reap()
+	}
+	return pool
+}
+
+func (pool *ConnPool) get(host string) (*pooledConn, error) {
+	pool.lock.Lock()
+	defer pool.lock.Unlock()
+	if pool.closed {
+		return nil, ErrPoolClosed
+	}
+	pc, ok := pool.conns[host]
+	if !ok {
+		pc = &pooledConn{}
+		pool.conns[host] = pc
+	}
+	return pc, nil
+}
+
+func (pool *ConnPool) Query(host string, req interface{}, dst interface{}) error {
+	payload, err := marshalRequest(req)
+	if err != nil {
+		return &netError{err}
+	}
+	pc, err := pool.get(host)
+	if err != nil {
+		return err
+	}
+	pc.lock.Lock()
+	defer pc.lock.Unlock()
+	plain, err := pc.roundTrip(host, payload)
+	if err != nil {
+		return err
+	}
+	return unmarshalResponse(plain, dst)
+}
+
+func (pc *pooledConn) roundTrip(host string, payload []byte) ([]byte, error) {
+	reused := pc.conn != nil
+	plain, err := pc.tryRoundTrip(host, payload)
+	if err != nil && reused && isStaleConn(err) {
+		// the device closed the idle connection out from under us;
+		// reconnect and try once more
+		plain, err = pc.tryRoundTrip(host, payload)
+	}
+	return plain, err
+}
+
+func (pc *pooledConn) tryRoundTrip(host string, payload []byte) ([]byte, error) {
+	if pc.conn == nil {
+		conn, err := net.DialTimeout("tcp", deviceAddr(host), defaultTimeout(0))
+		if err != nil {
+			return nil, &netError{err}
+		}
+		pc.conn = conn
+	}
+	err := writeMessage(pc.conn, payload, 0)
+	if err == nil {
+		var plain []byte
+		plain, err = readMessage(pc.conn, 0)
+		if err == nil {
+			pc.lastUsed = time.Now()
+			return plain, nil
+		}
+	}
+	pc.conn.Close()
+	pc.conn = nil
+	return nil, err
+}
+
+func isStaleConn(err error) bool {
+	return errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.EPIPE)
+}
+
+func (pool *ConnPool) reap() {
+	ticker := time.NewTicker(pool.IdleTimeout)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-pool.stop:
+			return
+		case <-ticker.C:
+			pool.closeIdle(time.Now().Add(-pool.IdleTimeout))
+		}
+	}
+}
+
+func (pool *ConnPool) closeIdle(before time.Time) {
+	pool.lock.Lock()
+	pcs := make([]*pooledConn, 0, len(pool.conns))
+	for _, pc := range pool.conns {
+		pcs = append(pcs, pc)
+	}
+	pool.lock.Unlock()
+	for _, pc := range pcs {
+		// don't wait on connections that are busy; they aren't idle
+		if !pc.lock.TryLock() {
+			continue
+		}
+		if pc.conn != nil && pc.lastUsed.Before(before) {
+			pc.conn.Close()
+			pc.conn = nil
+		}
+		pc.lock.Unlock()
+	}
+}
+
+// Close closes all pooled connections.  Subsequent queries fail with
+// ErrPoolClosed.
+func (pool *ConnPool) Close() error {
+	pool.lock.Lock()
+	if pool.closed {
+		pool.lock.Unlock()
+		return nil
+	}
+	pool.closed = true
+	close(pool.stop)
+	pcs := pool.conns
+	pool.conns = map[string]*pooledConn{}
+	pool.lock.Unlock()
+	for _, pc := range pcs {
+		pc.lock.Lock()
+		if pc.conn != nil {
+			pc.conn.Close()
+			pc.conn = nil
+		}
+		pc.lock.Unlock()
+	}
+	return nil
+}
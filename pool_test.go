@@ -0,0 +1,152 @@
+package kasa
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// poolServer speaks the TCP framing on a local port and answers every
+// request with the alias "conn N", N counting the connections accepted.
+// Each connection is closed after closeAfter requests, if closeAfter is
+// more than zero.  Connections the client closes are reported on hangups.
+type poolServer struct {
+	listener net.Listener
+	closeAfter int
+	hangups chan int
+	lock sync.Mutex
+	accepts int
+}
+
+func newPoolServer(t *testing.T, closeAfter int) *poolServer {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &poolServer{listener: l, closeAfter: closeAfter, hangups: make(chan int, 10)}
+	setDevicePort(t, l.Addr())
+	go srv.serve()
+	t.Cleanup(func() { l.Close() })
+	return srv
+}
+
+// setDevicePort points device queries at addr's port until the test ends.
+func setDevicePort(t *testing.T, addr net.Addr) {
+	_, port, _ := net.SplitHostPort(addr.String())
+	devicePort, _ = strconv.Atoi(port)
+	t.Cleanup(func() { devicePort = DEFAULT_PORT })
+}
+
+func (srv *poolServer) Accepts() int {
+	srv.lock.Lock()
+	defer srv.lock.Unlock()
+	return srv.accepts
+}
+
+func (srv *poolServer) serve() {
+	for {
+		conn, err := srv.listener.Accept()
+		if err != nil {
+			return
+		}
+		srv.lock.Lock()
+		srv.accepts++
+		n := srv.accepts
+		srv.lock.Unlock()
+		go func() {
+			defer conn.Close()
+			for i := 0; srv.closeAfter <= 0 || i < srv.closeAfter; i++ {
+				_, err := readMessage(conn, 5 * time.Second)
+				if err != nil {
+					srv.hangups <- n
+					return
+				}
+				res := fmt.Sprintf(`{"system":{"get_sysinfo":{"alias":"conn %d"}}}`, n)
+				err = writeMessage(conn, []byte(res), time.Second)
+				if err != nil {
+					return
+				}
+			}
+		}()
+	}
+}
+
+func poolAlias(t *testing.T, pool *ConnPool) string {
+	info := &Query{}
+	err := pool.Query("127.0.0.1", map[string]interface{}{"system": map[string]interface{}{"get_sysinfo": nil}}, info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return info.System.SysInfo.Alias
+}
+
+func TestConnPoolReconnect(t *testing.T) {
+	tests := []struct {
+		name string
+		closeAfter int
+		want []string
+	}{
+		{"reused", 0, []string{"conn 1", "conn 1", "conn 1"}},
+		{"closed after every reply", 1, []string{"conn 1", "conn 2", "conn 3"}},
+		{"closed after two replies", 2, []string{"conn 1", "conn 1", "conn 2"}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			srv := newPoolServer(t, tc.closeAfter)
+			pool := NewConnPool(0)
+			defer pool.Close()
+			for i, want := range tc.want {
+				// give the server's close time to reach us, so the next
+				// query finds a stale connection rather than racing it
+				time.Sleep(20 * time.Millisecond)
+				if got := poolAlias(t, pool); got != want {
+					t.Errorf("query %d answered by %s, want %s", i, got, want)
+				}
+			}
+			conns := map[string]bool{}
+			for _, want := range tc.want {
+				conns[want] = true
+			}
+			if srv.Accepts() != len(conns) {
+				t.Errorf("%d connections accepted, want %d", srv.Accepts(), len(conns))
+			}
+		})
+	}
+}
+
+func TestConnPoolIdleEviction(t *testing.T) {
+	srv := newPoolServer(t, 0)
+	pool := NewConnPool(30 * time.Millisecond)
+	defer pool.Close()
+	if got := poolAlias(t, pool); got != "conn 1" {
+		t.Fatalf("first query answered by %s", got)
+	}
+	select {
+	case n := <-srv.hangups:
+		if n != 1 {
+			t.Fatalf("connection %d closed, want 1", n)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("idle connection was not closed")
+	}
+	if got := poolAlias(t, pool); got != "conn 2" {
+		t.Errorf("query after eviction answered by %s, want conn 2", got)
+	}
+	if srv.Accepts() != 2 {
+		t.Errorf("%d connections accepted, want 2", srv.Accepts())
+	}
+}
+
+func TestConnPoolClosed(t *testing.T) {
+	newPoolServer(t, 0)
+	pool := NewConnPool(0)
+	poolAlias(t, pool)
+	pool.Close()
+	err := pool.Query("127.0.0.1", map[string]interface{}{}, &Query{})
+	if err != ErrPoolClosed {
+		t.Errorf("query after Close = %v, want ErrPoolClosed", err)
+	}
+}
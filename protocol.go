@@ -13,8 +13,11 @@ import (
 const (
 	INITIALIZATION_VECTOR = 171
 	DEFAULT_PORT = 9999
+	DISCOVERY_PORT = 20002
 	DEFAULT_TIMEOUT = 5
 	BLOCK_SIZE = 4
+	DEFAULT_UDP_RESPONSE_SIZE = 8192
+	MAX_UDP_PAYLOAD = 65507
 )
 
 type netError struct {
@@ -29,45 +32,156 @@ func (nerr *netError) Unwrap() error {
 	return nerr.realError
 }
 
-func query(host string, req interface{}, dst interface{}) error {
-	payload, err := json.Marshal(req)
+// Transport sends a single request to a device and decodes its response
+// into dst.
+type Transport interface {
+	Query(host string, req interface{}, dst interface{}) error
+}
+
+type tcpTransport struct{
+	timeout time.Duration
+}
+
+// TCPTransport opens a fresh TCP connection for every request.  It is the
+// default transport for devices that don't have one set.
+var TCPTransport Transport = tcpTransport{}
+
+func (t tcpTransport) Query(host string, req interface{}, dst interface{}) error {
+	return queryTimeout(host, req, dst, t.timeout)
+}
+
+// UDPTransport sends each request as a single encrypted, unframed datagram
+// to the device's port, the same way discovery does, and waits for one
+// datagram in reply.  Requests larger than MAX_UDP_PAYLOAD are rejected
+// without being sent.
+type UDPTransport struct {
+	// Timeout bounds the wait for a response.  Zero means DEFAULT_TIMEOUT
+	// seconds.
+	Timeout time.Duration
+	// MaxResponseSize is the largest response accepted.  Zero means
+	// DEFAULT_UDP_RESPONSE_SIZE.
+	MaxResponseSize int
+}
+
+func (t *UDPTransport) Query(host string, req interface{}, dst interface{}) error {
+	payload, err := marshalRequest(req)
 	if err != nil {
 		return &netError{err}
 	}
-	//log.Println("DEBUG:", string(payload))
-	conn, err := net.DialTimeout("tcp", fmt.Sprintf("%s:%d", host, DEFAULT_PORT), time.Duration(DEFAULT_TIMEOUT) * time.Second)
+	if len(payload) > MAX_UDP_PAYLOAD {
+		// not a network error; sending it again won't help
+		return fmt.Errorf("request to %s is %d bytes, more than fits in a datagram", host, len(payload))
+	}
+	timeout := defaultTimeout(t.Timeout)
+	maxSize := t.MaxResponseSize
+	if maxSize <= 0 {
+		maxSize = DEFAULT_UDP_RESPONSE_SIZE
+	}
+	conn, err := net.DialTimeout("udp", deviceAddr(host), timeout)
 	if err != nil {
 		return &netError{err}
 	}
 	defer conn.Close()
-	conn.SetWriteDeadline(time.Now().Add(time.Duration(DEFAULT_TIMEOUT) * time.Second))
-	err = binary.Write(conn, binary.BigEndian, int32(len(payload)))
+	conn.SetDeadline(time.Now().Add(timeout))
+	_, err = conn.Write(encrypt(payload))
+	if err != nil {
+		return &netError{err}
+	}
+	// read one byte more than allowed so oversized responses can be
+	// told apart from ones that exactly fill the buffer
+	buf := make([]byte, maxSize + 1)
+	n, err := conn.Read(buf)
 	if err != nil {
 		return &netError{err}
 	}
+	if n > maxSize {
+		return fmt.Errorf("response from %s exceeds %d bytes", host, maxSize)
+	}
+	return unmarshalResponse(decrypt(buf[:n]), dst)
+}
+
+// devicePort is the port devices are queried on.  Tests point it at a
+// local listener.
+var devicePort = DEFAULT_PORT
+
+func deviceAddr(host string) string {
+	return fmt.Sprintf("%s:%d", host, devicePort)
+}
+
+func marshalRequest(req interface{}) ([]byte, error) {
+	payload, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
 	if Debug {
 		log.Println(string(payload))
 	}
-	_, err = conn.Write(encrypt(payload))
+	return payload, nil
+}
+
+func unmarshalResponse(plain []byte, dst interface{}) error {
+	if Debug {
+		log.Println(string(plain))
+	}
+	return json.Unmarshal(plain, dst)
+}
+
+func defaultTimeout(timeout time.Duration) time.Duration {
+	if timeout <= 0 {
+		return time.Duration(DEFAULT_TIMEOUT) * time.Second
+	}
+	return timeout
+}
+
+func writeMessage(conn net.Conn, payload []byte, timeout time.Duration) error {
+	conn.SetWriteDeadline(time.Now().Add(defaultTimeout(timeout)))
+	msg := make([]byte, BLOCK_SIZE + len(payload))
+	binary.BigEndian.PutUint32(msg, uint32(len(payload)))
+	copy(msg[BLOCK_SIZE:], encrypt(payload))
+	_, err := conn.Write(msg)
 	if err != nil {
 		return &netError{err}
 	}
-	conn.SetReadDeadline(time.Now().Add(time.Duration(DEFAULT_TIMEOUT) * time.Second))
+	return nil
+}
+
+func readMessage(conn net.Conn, timeout time.Duration) ([]byte, error) {
+	conn.SetReadDeadline(time.Now().Add(defaultTimeout(timeout)))
 	var respLen int32
-	err = binary.Read(conn, binary.BigEndian, &respLen)
+	err := binary.Read(conn, binary.BigEndian, &respLen)
 	if err != nil {
-		return &netError{err}
+		return nil, &netError{err}
+	}
+	if respLen < 0 {
+		return nil, &netError{fmt.Errorf("invalid response length %d", respLen)}
 	}
 	cipher := make([]byte, int(respLen))
 	_, err = io.ReadFull(conn, cipher)
+	if err != nil {
+		return nil, &netError{err}
+	}
+	return decrypt(cipher), nil
+}
+
+func queryTimeout(host string, req interface{}, dst interface{}, timeout time.Duration) error {
+	payload, err := marshalRequest(req)
 	if err != nil {
 		return &netError{err}
 	}
-	plain := decrypt(cipher)
-	if Debug {
-		log.Println(string(plain))
+	conn, err := net.DialTimeout("tcp", deviceAddr(host), defaultTimeout(timeout))
+	if err != nil {
+		return &netError{err}
 	}
-	return json.Unmarshal(plain, dst)
+	defer conn.Close()
+	err = writeMessage(conn, payload, timeout)
+	if err != nil {
+		return err
+	}
+	plain, err := readMessage(conn, timeout)
+	if err != nil {
+		return err
+	}
+	return unmarshalResponse(plain, dst)
 }
 
 func encrypt(plain []byte) []byte {
@@ -0,0 +1,242 @@
+package kasa
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"sync"
+	"time"
+)
+
+const DEFAULT_LOST_AFTER = 3
+
+type DeviceEventType string
+
+const (
+	DeviceAdded = DeviceEventType("added")
+	DeviceUpdated = DeviceEventType("updated")
+	DeviceLost = DeviceEventType("lost")
+)
+
+type DeviceEvent struct {
+	Type DeviceEventType `json:"type"`
+	Device SmartDevice `json:"device"`
+	// PreviousIP is set on updates when the device moved to a new address.
+	PreviousIP string `json:"previous_ip,omitempty"`
+	Time time.Time `json:"time"`
+}
+
+type registryEntry struct {
+	device SmartDevice
+	state []byte
+	lastRound int
+}
+
+// Registry tracks devices across repeated discovery rounds, keyed by
+// device ID (or MAC address for devices that don't report one) rather than
+// IP address, so a device that changes address is still the same device.
+type Registry struct {
+	// LostAfter is the number of consecutive rounds a device must miss
+	// to be reported lost.
+	LostAfter int
+	lock sync.Mutex
+	entries map[string]*registryEntry
+	// macs maps normalized MAC addresses to entry keys
+	macs map[string]string
+	round int
+}
+
+func NewRegistry(lostAfter int) *Registry {
+	if lostAfter < 1 {
+		lostAfter = DEFAULT_LOST_AFTER
+	}
+	return &Registry{
+		LostAfter: lostAfter,
+		entries: map[string]*registryEntry{},
+		macs: map[string]string{},
+	}
+}
+
+func deviceKey(dev SmartDevice) string {
+	id := dev.DeviceID()
+	if id != "" {
+		return id
+	}
+	return strings.ToUpper(dev.MAC())
+}
+
+// deviceState summarizes the parts of a device's sysinfo that matter for
+// change detection, leaving out counters that change on every reply.
+func deviceState(dev SmartDevice) []byte {
+	sysinfo := dev.GetSysInfo().clone()
+	if sysinfo == nil {
+		return nil
+	}
+	sysinfo.LastUpdate = time.Time{}
+	sysinfo.OnTime = 0
+	sysinfo.RSSI = 0
+	for _, child := range sysinfo.Children {
+		child.OnTime = 0
+	}
+	data, _ := json.Marshal(sysinfo)
+	return data
+}
+
+// Observe records a discovery reply and returns the resulting event, or
+// nil if the device is already known and nothing about it changed.
+func (reg *Registry) Observe(dev SmartDevice) *DeviceEvent {
+	key := deviceKey(dev)
+	if key == "" {
+		return nil
+	}
+	state := deviceState(dev)
+	reg.lock.Lock()
+	defer reg.lock.Unlock()
+	mac := normalizeMAC(dev.MAC())
+	entry, ok := reg.entries[key]
+	if !ok && mac != "" {
+		// the same device may be known under another key, e.g. from a reply
+		// that didn't include its device ID
+		if oldKey, found := reg.macs[mac]; found {
+			key = oldKey
+			entry, ok = reg.entries[key]
+		}
+	}
+	if mac != "" {
+		reg.macs[mac] = key
+	}
+	if !ok {
+		reg.entries[key] = &registryEntry{device: dev, state: state, lastRound: reg.round}
+		return &DeviceEvent{Type: DeviceAdded, Device: dev, Time: time.Now()}
+	}
+	prev := entry.device
+	entry.device = dev
+	entry.lastRound = reg.round
+	ipChanged := prev.IP() != dev.IP()
+	stateChanged := !bytes.Equal(entry.state, state)
+	entry.state = state
+	if !ipChanged && !stateChanged {
+		return nil
+	}
+	evt := &DeviceEvent{Type: DeviceUpdated, Device: dev, Time: time.Now()}
+	if ipChanged {
+		evt.PreviousIP = prev.IP()
+	}
+	return evt
+}
+
+// EndRound marks the end of a discovery round, removes devices that have
+// missed LostAfter rounds in a row, and returns a lost event for each.
+func (reg *Registry) EndRound() []*DeviceEvent {
+	reg.lock.Lock()
+	defer reg.lock.Unlock()
+	events := []*DeviceEvent{}
+	for key, entry := range reg.entries {
+		// rounds missed, counting this one; zero if it answered this round
+		missed := reg.round - entry.lastRound
+		if missed >= reg.LostAfter {
+			delete(reg.entries, key)
+			if mac := normalizeMAC(entry.device.MAC()); reg.macs[mac] == key {
+				delete(reg.macs, mac)
+			}
+			events = append(events, &DeviceEvent{Type: DeviceLost, Device: entry.device, Time: time.Now()})
+		}
+	}
+	reg.round += 1
+	return events
+}
+
+// Get returns the device with the given device ID or MAC address, or nil.
+// MAC addresses match regardless of case or separators.
+func (reg *Registry) Get(key string) SmartDevice {
+	reg.lock.Lock()
+	defer reg.lock.Unlock()
+	entry, ok := reg.entries[key]
+	if !ok {
+		entry, ok = reg.entries[strings.ToUpper(key)]
+	}
+	if !ok {
+		if macKey, found := reg.macs[normalizeMAC(key)]; found {
+			entry, ok = reg.entries[macKey]
+		}
+	}
+	if !ok {
+		return nil
+	}
+	return entry.device
+}
+
+func (reg *Registry) Devices() []SmartDevice {
+	reg.lock.Lock()
+	defer reg.lock.Unlock()
+	devices := make([]SmartDevice, 0, len(reg.entries))
+	for _, entry := range reg.entries {
+		devices = append(devices, entry.device)
+	}
+	return devices
+}
+
+// Run rediscovers devices every retry interval until ctx is done, and
+// reports added, updated and lost devices on the returned channel.  Each
+// of the scanner's probe rounds is one registry round.
+func (reg *Registry) Run(ctx context.Context, retry time.Duration, opts *DiscoverOptions) (chan *DeviceEvent, error) {
+	scanner := NewScanner(retry, opts)
+	scanner.Rounds = make(chan struct{}, 1)
+	devch, err := scanner.Start(ctx)
+	if err != nil {
+		return nil, err
+	}
+	ch := make(chan *DeviceEvent, 10)
+	go func() {
+		defer close(ch)
+		send := func(evt *DeviceEvent) bool {
+			select {
+			case ch <- evt:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+		observe := func(dev SmartDevice) bool {
+			evt := reg.Observe(dev)
+			return evt == nil || send(evt)
+		}
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case dev, ok := <-devch:
+				if !ok {
+					return
+				}
+				if !observe(dev) {
+					return
+				}
+			case <-scanner.Rounds:
+				// the round's replies are queued ahead of its end, so
+				// take them before counting who missed it
+			drain:
+				for {
+					select {
+					case dev, ok := <-devch:
+						if !ok {
+							return
+						}
+						if !observe(dev) {
+							return
+						}
+					default:
+						break drain
+					}
+				}
+				for _, evt := range reg.EndRound() {
+					if !send(evt) {
+						return
+					}
+				}
+			}
+		}
+	}()
+	return ch, nil
+}
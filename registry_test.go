@@ -0,0 +1,91 @@
+package kasa
+
+import (
+	"testing"
+)
+
+func registryDevice(id, mac, ip string) SmartDevice {
+	sysinfo := &SysInfo{DeviceID: id, MACAddr: mac, Alias: "plug", MicType: "IOT.SMARTPLUGSWITCH"}
+	dev := &BaseDevice{Addr: ip, Info: &Query{System: &SysInfoResponse{SysInfo: sysinfo}}}
+	return dev.AsConcrete()
+}
+
+func TestRegistryGet(t *testing.T) {
+	reg := NewRegistry(1)
+	reg.Observe(registryDevice("ID1", "AA:BB:CC:DD:EE:01", "192.0.2.1"))
+	reg.Observe(registryDevice("", "AA:BB:CC:DD:EE:02", "192.0.2.2"))
+	tests := []struct {
+		key string
+		ip string
+	}{
+		{"ID1", "192.0.2.1"},
+		{"AA:BB:CC:DD:EE:01", "192.0.2.1"},
+		{"aa-bb-cc-dd-ee-01", "192.0.2.1"},
+		{"AABBCCDDEE02", "192.0.2.2"},
+		{"AA:BB:CC:DD:EE:03", ""},
+	}
+	for _, tc := range tests {
+		dev := reg.Get(tc.key)
+		if tc.ip == "" {
+			if dev != nil {
+				t.Errorf("Get(%s) = %s, want nil", tc.key, dev.IP())
+			}
+			continue
+		}
+		if dev == nil || dev.IP() != tc.ip {
+			t.Errorf("Get(%s) = %v, want device at %s", tc.key, dev, tc.ip)
+		}
+	}
+}
+
+func TestRegistryDedupesByMAC(t *testing.T) {
+	reg := NewRegistry(1)
+	if evt := reg.Observe(registryDevice("", "AA:BB:CC:DD:EE:01", "192.0.2.1")); evt == nil || evt.Type != DeviceAdded {
+		t.Fatalf("first observation: %v", evt)
+	}
+	evt := reg.Observe(registryDevice("ID1", "AA:BB:CC:DD:EE:01", "192.0.2.7"))
+	if evt == nil || evt.Type != DeviceUpdated || evt.PreviousIP != "192.0.2.1" {
+		t.Fatalf("same MAC with a device ID: %+v", evt)
+	}
+	if n := len(reg.Devices()); n != 1 {
+		t.Fatalf("%d devices, want 1", n)
+	}
+	reg.EndRound()
+	if dev := reg.Get("AA:BB:CC:DD:EE:01"); dev == nil {
+		t.Fatalf("device that answered this round not found by MAC")
+	}
+	reg.EndRound()
+	if dev := reg.Get("AA:BB:CC:DD:EE:01"); dev != nil {
+		t.Fatalf("lost device still found by MAC")
+	}
+}
+
+func TestRegistryLostAfter(t *testing.T) {
+	for lostAfter := 1; lostAfter <= 3; lostAfter++ {
+		reg := NewRegistry(lostAfter)
+		reg.Observe(registryDevice("ID1", "AA:BB:CC:DD:EE:01", "192.0.2.1"))
+		// the round it answered in doesn't count as missed
+		rounds := 0
+		for len(reg.Devices()) > 0 && rounds < 10 {
+			events := reg.EndRound()
+			missed := rounds
+			rounds++
+			lost := len(events) == 1 && events[0].Type == DeviceLost
+			if lost != (missed == lostAfter) {
+				t.Errorf("LostAfter %d: lost = %t after missing %d rounds", lostAfter, lost, missed)
+			}
+		}
+		if rounds != lostAfter + 1 {
+			t.Errorf("LostAfter %d: lost after %d rounds, want %d", lostAfter, rounds, lostAfter + 1)
+		}
+		// answering again resets the count
+		reg.Observe(registryDevice("ID1", "AA:BB:CC:DD:EE:01", "192.0.2.1"))
+		for i := 0; i < lostAfter; i++ {
+			reg.EndRound()
+		}
+		reg.Observe(registryDevice("ID1", "AA:BB:CC:DD:EE:01", "192.0.2.1"))
+		if events := reg.EndRound(); len(events) != 0 {
+			t.Errorf("LostAfter %d: device that answered this round reported %s", lostAfter, events[0].Type)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package kasa
+
+import (
+	"errors"
+	"math"
+	"math/rand"
+	"time"
+)
+
+// RetryPolicy controls how many times, and how patiently, a device query
+// is retried after a failure.
+type RetryPolicy struct {
+	// MaxAttempts is the total number of attempts, including the first.
+	// Values less than 1 are treated as 1.
+	MaxAttempts int
+	// Backoff is the delay before the first retry.
+	Backoff time.Duration
+	// MaxBackoff caps the delay between attempts.  Zero means no cap.
+	MaxBackoff time.Duration
+	// Multiplier grows the delay after each retry.  Values less than 1
+	// are treated as 1 (a flat delay).
+	Multiplier float64
+	// Jitter randomizes each delay by up to +/- this fraction of it.
+	Jitter float64
+	// Budget is the total time allowed for all attempts and delays.  Zero
+	// means no limit.
+	Budget time.Duration
+	// Retryable decides whether an error is worth retrying.  If nil,
+	// only network errors are retried.
+	Retryable func(error) bool
+}
+
+// DefaultRetryPolicy is used by devices that don't have their own policy:
+// three attempts one second apart, retrying only network errors.
+var DefaultRetryPolicy = &RetryPolicy{
+	MaxAttempts: 3,
+	Backoff: time.Second,
+	Multiplier: 1,
+}
+
+// NoRetry makes a single attempt and returns its error immediately.
+var NoRetry = &RetryPolicy{MaxAttempts: 1}
+
+// IsNetError reports whether err was caused by a network failure while
+// talking to a device, as opposed to e.g. a malformed response.
+func IsNetError(err error) bool {
+	var nerr *netError
+	return errors.As(err, &nerr)
+}
+
+func (p *RetryPolicy) attempts() int {
+	if p.MaxAttempts < 1 {
+		return 1
+	}
+	return p.MaxAttempts
+}
+
+func (p *RetryPolicy) retryable(err error) bool {
+	if p.Retryable == nil {
+		return IsNetError(err)
+	}
+	return p.Retryable(err)
+}
+
+func (p *RetryPolicy) delay(retry int) time.Duration {
+	mult := p.Multiplier
+	if mult < 1 {
+		mult = 1
+	}
+	d := float64(p.Backoff) * math.Pow(mult, float64(retry))
+	if p.MaxBackoff > 0 && d > float64(p.MaxBackoff) {
+		d = float64(p.MaxBackoff)
+	}
+	if p.Jitter > 0 {
+		d += d * p.Jitter * (rand.Float64() * 2 - 1)
+	}
+	if d < 0 {
+		return 0
+	}
+	return time.Duration(d)
+}
+
+// Do calls fn until it succeeds, returns an error the policy doesn't
+// consider retryable, or the attempts or time budget run out.  The last
+// error is returned.
+func (p *RetryPolicy) Do(fn func() error) error {
+	if p == nil {
+		p = DefaultRetryPolicy
+	}
+	start := time.Now()
+	var err error
+	for i := 0; i < p.attempts(); i += 1 {
+		if i > 0 {
+			d := p.delay(i - 1)
+			if p.Budget > 0 && time.Since(start) + d >= p.Budget {
+				return err
+			}
+			time.Sleep(d)
+		}
+		err = fn()
+		if err == nil {
+			return nil
+		}
+		if !p.retryable(err) {
+			return err
+		}
+	}
+	return err
+}
@@ -0,0 +1,80 @@
+package kasa
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRetryPolicyDelay(t *testing.T) {
+	tests := []struct {
+		name string
+		policy RetryPolicy
+		retry int
+		want time.Duration
+	}{
+		{"flat", RetryPolicy{Backoff: time.Second}, 3, time.Second},
+		{"multiplier below 1 is flat", RetryPolicy{Backoff: time.Second, Multiplier: 0.5}, 2, time.Second},
+		{"first retry", RetryPolicy{Backoff: time.Second, Multiplier: 2}, 0, time.Second},
+		{"exponential", RetryPolicy{Backoff: time.Second, Multiplier: 2}, 3, 8 * time.Second},
+		{"capped", RetryPolicy{Backoff: time.Second, Multiplier: 2, MaxBackoff: 5 * time.Second}, 3, 5 * time.Second},
+	}
+	for _, tc := range tests {
+		if got := tc.policy.delay(tc.retry); got != tc.want {
+			t.Errorf("%s: delay(%d) = %s, want %s", tc.name, tc.retry, got, tc.want)
+		}
+	}
+}
+
+func TestRetryPolicyJitter(t *testing.T) {
+	p := &RetryPolicy{Backoff: time.Second, Jitter: 0.25}
+	for i := 0; i < 100; i++ {
+		d := p.delay(0)
+		if d < 750 * time.Millisecond || d > 1250 * time.Millisecond {
+			t.Fatalf("jittered delay %s outside +/-25%% of 1s", d)
+		}
+	}
+}
+
+func TestRetryPolicyDo(t *testing.T) {
+	netErr := &netError{errors.New("connection refused")}
+	otherErr := errors.New("bad response")
+	tests := []struct {
+		name string
+		policy *RetryPolicy
+		errs []error
+		wantCalls int
+		wantErr error
+	}{
+		{"success", &RetryPolicy{MaxAttempts: 3}, []error{nil}, 1, nil},
+		{"succeeds after retries", &RetryPolicy{MaxAttempts: 3}, []error{netErr, netErr, nil}, 3, nil},
+		{"attempts exhausted", &RetryPolicy{MaxAttempts: 3}, []error{netErr, netErr, netErr, nil}, 3, netErr},
+		{"zero attempts means one", &RetryPolicy{}, []error{netErr, nil}, 1, netErr},
+		{"non-network error not retried", &RetryPolicy{MaxAttempts: 3}, []error{otherErr, nil}, 1, otherErr},
+		{"custom predicate", &RetryPolicy{MaxAttempts: 3, Retryable: func(err error) bool { return err == otherErr }}, []error{otherErr, netErr, nil}, 2, netErr},
+		{"budget stops retries", &RetryPolicy{MaxAttempts: 5, Backoff: 50 * time.Millisecond, Budget: 120 * time.Millisecond}, []error{netErr, netErr, netErr, netErr, nil}, 3, netErr},
+	}
+	for _, tc := range tests {
+		calls := 0
+		err := tc.policy.Do(func() error {
+			err := tc.errs[calls]
+			calls++
+			return err
+		})
+		if calls != tc.wantCalls {
+			t.Errorf("%s: %d calls, want %d", tc.name, calls, tc.wantCalls)
+		}
+		if err != tc.wantErr {
+			t.Errorf("%s: err = %v, want %v", tc.name, err, tc.wantErr)
+		}
+	}
+}
+
+func TestIsNetError(t *testing.T) {
+	if !IsNetError(&netError{errors.New("timeout")}) {
+		t.Error("netError not recognized")
+	}
+	if IsNetError(errors.New("timeout")) {
+		t.Error("plain error treated as network error")
+	}
+}
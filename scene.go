@@ -0,0 +1,232 @@
+package kasa
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// SceneState is the state a scene puts one device in.  Nil fields are
+// left as they are.  Hue, Saturation and ColorTemp only apply to bulbs,
+// and a ColorTemp other than zero takes precedence over hue and
+// saturation.
+type SceneState struct {
+	DeviceID string `json:"device_id" yaml:"device_id"`
+	// Alias is informational, to make scene files readable.
+	Alias string `json:"alias,omitempty" yaml:"alias,omitempty"`
+	On *bool `json:"on,omitempty" yaml:"on,omitempty"`
+	Brightness *int `json:"brightness,omitempty" yaml:"brightness,omitempty"`
+	Hue *int `json:"hue,omitempty" yaml:"hue,omitempty"`
+	Saturation *int `json:"saturation,omitempty" yaml:"saturation,omitempty"`
+	ColorTemp *int `json:"color_temp,omitempty" yaml:"color_temp,omitempty"`
+	// TransitionPeriod is how long bulbs take to change, in milliseconds.
+	TransitionPeriod int `json:"transition_period,omitempty" yaml:"transition_period,omitempty"`
+}
+
+// Scene is a set of device states applied together, such as "movie
+// night".
+type Scene struct {
+	Name string `json:"name" yaml:"name"`
+	Devices []*SceneState `json:"devices" yaml:"devices"`
+	// Concurrency limits how many devices are changed at once,
+	// DEFAULT_GROUP_CONCURRENCY if zero.
+	Concurrency int `json:"-" yaml:"-"`
+}
+
+// sceneDevices replaces strips with their sockets, since the sockets are
+// what scenes switch.
+func sceneDevices(devices []SmartDevice) []SmartDevice {
+	xdevices := []SmartDevice{}
+	for _, dev := range devices {
+		if strip, ok := dev.(*SmartStrip); ok {
+			for _, socket := range strip.Children() {
+				xdevices = append(xdevices, socket)
+			}
+			continue
+		}
+		xdevices = append(xdevices, dev)
+	}
+	return xdevices
+}
+
+func intPtr(i int) *int {
+	return &i
+}
+
+func boolPtr(b bool) *bool {
+	return &b
+}
+
+func captureState(dev SmartDevice) *SceneState {
+	state := &SceneState{
+		DeviceID: dev.DeviceID(),
+		Alias: dev.Alias(),
+	}
+	if isBulbFamily(dev) {
+		light := dev.GetSysInfo().LightState
+		if light == nil {
+			return state
+		}
+		state.On = boolPtr(light.OnOff != 0)
+		if light.OnOff == 0 {
+			// an off bulb doesn't report the rest of its state
+			return state
+		}
+		if dev.IsDimmable() {
+			state.Brightness = intPtr(light.Brightness)
+		}
+		if dev.IsVariableColorTemp() && light.ColorTemp != 0 {
+			state.ColorTemp = intPtr(light.ColorTemp)
+		} else if dev.IsColor() {
+			state.Hue = intPtr(light.Hue)
+			state.Saturation = intPtr(light.Saturation)
+			state.ColorTemp = intPtr(0)
+		}
+		return state
+	}
+	if _, ok := dev.(Switch); ok {
+		state.On = boolPtr(dev.IsOn())
+	}
+	if dimmer, ok := dev.(*SmartDimmer); ok {
+		state.Brightness = intPtr(dimmer.Brightness())
+	}
+	return state
+}
+
+// CaptureScene records the current state of devices, going by their cached
+// sysinfo, so call Update on them first if it may be stale.  Strips are
+// recorded socket by socket.
+func CaptureScene(name string, devices []SmartDevice) *Scene {
+	scene := &Scene{Name: name}
+	for _, dev := range sceneDevices(devices) {
+		if dev.DeviceType() == DeviceTypeEncrypted || dev.GetSysInfo() == nil {
+			continue
+		}
+		scene.Devices = append(scene.Devices, captureState(dev))
+	}
+	return scene
+}
+
+func (state *SceneState) lightRequest() *LightStateRequest {
+	req := &LightStateRequest{
+		Brightness: state.Brightness,
+		Hue: state.Hue,
+		Saturation: state.Saturation,
+		ColorTemp: state.ColorTemp,
+		TransitionPeriod: state.TransitionPeriod,
+		IgnoreDefault: 1,
+	}
+	if state.On != nil {
+		req.OnOff = intPtr(0)
+		if *state.On {
+			req.OnOff = intPtr(1)
+		}
+	}
+	if req.ColorTemp != nil && *req.ColorTemp != 0 {
+		req.Hue = nil
+		req.Saturation = nil
+	}
+	return req
+}
+
+func (state *SceneState) apply(dev SmartDevice) error {
+	if isBulbFamily(dev) {
+		light, ok := dev.Module(LightModule).(*Light)
+		if !ok {
+			return errNoModule(dev, LightModule)
+		}
+		_, err := light.SetState(state.lightRequest())
+		return err
+	}
+	if state.On != nil && !*state.On {
+		sw, ok := dev.(Switch)
+		if !ok {
+			return ErrNotSwitch
+		}
+		return sw.TurnOff()
+	}
+	if state.Brightness != nil {
+		dimmer, ok := dev.(Dimmer)
+		if !ok || !dev.IsDimmable() {
+			return ErrNotDimmable
+		}
+		// this turns the dimmer on too
+		return dimmer.SetBrightness(*state.Brightness)
+	}
+	if state.On != nil {
+		sw, ok := dev.(Switch)
+		if !ok {
+			return ErrNotSwitch
+		}
+		return sw.TurnOn()
+	}
+	return nil
+}
+
+// Apply puts devices in the scene's states, matching them by device ID.
+// Devices in the scene that aren't among devices are reported with
+// ErrDeviceNotFound; devices not in the scene are left alone.
+func (scene *Scene) Apply(ctx context.Context, devices []SmartDevice) GroupResults {
+	states := map[string]*SceneState{}
+	for _, state := range scene.Devices {
+		states[state.DeviceID] = state
+	}
+	group := NewGroup(scene.Name)
+	if scene.Concurrency > 0 {
+		group.Concurrency = scene.Concurrency
+	}
+	for _, dev := range sceneDevices(devices) {
+		if _, ok := states[dev.DeviceID()]; ok {
+			group.Add(dev)
+		}
+	}
+	results := group.Do(ctx, func(ctx context.Context, dev SmartDevice) error {
+		return states[dev.DeviceID()].apply(dev)
+	})
+	for id := range states {
+		if _, ok := results[id]; !ok {
+			results[id] = ErrDeviceNotFound
+		}
+	}
+	return results
+}
+
+// LoadScene reads a scene from a .json or .yaml file.
+func LoadScene(fn string) (*Scene, error) {
+	data, err := os.ReadFile(fn)
+	if err != nil {
+		return nil, err
+	}
+	scene := &Scene{}
+	switch strings.ToLower(filepath.Ext(fn)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, scene)
+	default:
+		err = json.Unmarshal(data, scene)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return scene, nil
+}
+
+// Save writes the scene to a file, as YAML if its name ends in .yaml or
+// .yml and JSON otherwise.
+func (scene *Scene) Save(fn string) error {
+	var data []byte
+	var err error
+	switch strings.ToLower(filepath.Ext(fn)) {
+	case ".yaml", ".yml":
+		data, err = yaml.Marshal(scene)
+	default:
+		data, err = json.MarshalIndent(scene, "", "  ")
+	}
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(fn, data, 0644)
+}
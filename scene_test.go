@@ -0,0 +1,146 @@
+package kasa
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"testing"
+)
+
+// sceneLog records the requests sent to each device, by alias, answering
+// every command with success.
+type sceneLog struct {
+	lock sync.Mutex
+	sent map[string][]string
+}
+
+func (log *sceneLog) device(t *testing.T, sysinfo string) *BaseDevice {
+	dev := jsonTestDevice(t, sysinfo)
+	dev.SetRetryPolicy(NoRetry)
+	alias := dev.Alias()
+	dev.SetTransport(funcTransport(func(host string, req interface{}, dst interface{}) error {
+		// round trip through a map so keys come out sorted
+		data, _ := json.Marshal(req)
+		xreq := map[string]map[string]interface{}{}
+		json.Unmarshal(data, &xreq)
+		data, _ = json.Marshal(xreq)
+		log.lock.Lock()
+		log.sent[alias] = append(log.sent[alias], string(data))
+		log.lock.Unlock()
+		res := map[string]map[string]interface{}{}
+		for target, cmds := range xreq {
+			if target == "context" {
+				continue
+			}
+			res[target] = map[string]interface{}{}
+			for cmd := range cmds {
+				res[target][cmd] = map[string]interface{}{"err_code": 0}
+			}
+		}
+		data, _ = json.Marshal(res)
+		return json.Unmarshal(data, dst)
+	}))
+	return dev
+}
+
+func (log *sceneLog) reset() map[string][]string {
+	log.lock.Lock()
+	defer log.lock.Unlock()
+	sent := log.sent
+	log.sent = map[string][]string{}
+	return sent
+}
+
+func TestSceneApply(t *testing.T) {
+	log := &sceneLog{sent: map[string][]string{}}
+	plug := log.device(t, `{"alias":"lamp","model":"HS100(US)","deviceId":"PLUG","relay_state":1}`).AsConcrete().(Switch)
+	dimmer := log.device(t, `{"alias":"hall","model":"HS220(US)","deviceId":"DIMMER","relay_state":1,"brightness":40}`).AsConcrete().(*SmartDimmer)
+	bulb := log.device(t, `{"alias":"bulb","model":"KL130(US)","deviceId":"BULB","mic_type":"IOT.SMARTBULB","light_state":{"on_off":1,"brightness":80,"hue":120,"saturation":50,"color_temp":0}}`).AsConcrete()
+	strip := log.device(t, `{"alias":"strip","model":"HS300(US)","deviceId":"STRIP","relay_state":1,"children":[
+		{"id":"00","alias":"socket 1","state":1},
+		{"id":"01","alias":"socket 2","state":0}
+	]}`).AsConcrete()
+	devices := []SmartDevice{plug, dimmer, bulb, strip}
+	scene := CaptureScene("evening", devices)
+	if len(scene.Devices) != 5 {
+		t.Fatalf("captured %d devices, want 5", len(scene.Devices))
+	}
+
+	// change everything the scene covers
+	plug.TurnOff()
+	dimmer.SetBrightness(10)
+	bulb.Module(LightModule).(*Light).SetState(&LightStateRequest{OnOff: intPtr(0)})
+	for _, socket := range strip.(*SmartStrip).Children() {
+		socket.TurnOff()
+	}
+	if len(log.reset()) != 4 {
+		t.Fatal("changing state didn't reach every device")
+	}
+
+	results := scene.Apply(context.Background(), devices)
+	if err := results.Err(); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string][]string{
+		"lamp": {`{"system":{"set_relay_state":{"state":1}}}`},
+		"hall": {`{"smartlife.iot.dimmer":{"set_brightness":{"brightness":40}}}`},
+		"bulb": {`{"smartlife.iot.smartbulb.lightingservice":{"transition_light_state":{"brightness":80,"color_temp":0,"hue":120,"ignore_default":1,"on_off":1,"saturation":50}}}`},
+		"strip": {
+			`{"context":{"child_ids":["STRIP00"]},"system":{"set_relay_state":{"state":1}}}`,
+			`{"context":{"child_ids":["STRIP01"]},"system":{"set_relay_state":{"state":0}}}`,
+		},
+	}
+	sent := log.reset()
+	if len(sent) != len(want) {
+		t.Errorf("sent commands to %d devices, want %d: %v", len(sent), len(want), sent)
+	}
+	for alias, cmds := range want {
+		got := sent[alias]
+		// the sockets are switched concurrently
+		if len(got) == 2 && got[0] > got[1] {
+			got[0], got[1] = got[1], got[0]
+		}
+		if len(got) != len(cmds) {
+			t.Errorf("%s got %v, want %v", alias, got, cmds)
+			continue
+		}
+		for i := range cmds {
+			if got[i] != cmds[i] {
+				t.Errorf("%s got %s, want %s", alias, got[i], cmds[i])
+			}
+		}
+	}
+}
+
+func TestSceneApplyMissingDevices(t *testing.T) {
+	// pretend one model has no light service to set its state with
+	spec := lookupModuleSpec(LightModule)
+	defer RegisterModule(spec)
+	xspec := *spec
+	xspec.Detect = func(dev SmartDevice) bool {
+		return dev.GetSysInfo().Model != "KL130(US)"
+	}
+	RegisterModule(&xspec)
+	log := &sceneLog{sent: map[string][]string{}}
+	plug := log.device(t, `{"alias":"lamp","model":"HS100(US)","deviceId":"PLUG","relay_state":1}`).AsConcrete()
+	bulb := log.device(t, `{"alias":"bulb","model":"KL130(US)","deviceId":"BULB","mic_type":"IOT.SMARTBULB","light_state":{"on_off":1}}`).AsConcrete()
+	scene := &Scene{Name: "test", Devices: []*SceneState{
+		{DeviceID: "PLUG", On: boolPtr(false)},
+		{DeviceID: "BULB", On: boolPtr(true)},
+		{DeviceID: "GONE", On: boolPtr(true)},
+	}}
+	results := scene.Apply(context.Background(), []SmartDevice{plug, bulb})
+	if results["PLUG"] != nil {
+		t.Errorf("PLUG: %v", results["PLUG"])
+	}
+	if !errors.Is(results["BULB"], ErrNoModule) {
+		t.Errorf("BULB: %v, want %v", results["BULB"], ErrNoModule)
+	}
+	if results["GONE"] != ErrDeviceNotFound {
+		t.Errorf("GONE: %v, want %v", results["GONE"], ErrDeviceNotFound)
+	}
+	if sent := log.reset(); len(sent["lamp"]) != 1 || sent["lamp"][0] != `{"system":{"set_relay_state":{"state":0}}}` {
+		t.Errorf("lamp got %v", sent["lamp"])
+	}
+}
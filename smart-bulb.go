@@ -1,7 +1,6 @@
 package kasa
 
 import (
-	"errors"
 	"log"
 )
 
@@ -17,6 +16,15 @@ func (bulb *SmartBulb) GetLightState() *LightState {
 	return sysinfo.LightState
 }
 
+func (bulb *SmartBulb) patchLightState(fn func(light *LightState)) {
+	bulb.patchSysInfo(func(sysinfo *SysInfo) {
+		if sysinfo.LightState == nil {
+			sysinfo.LightState = &LightState{}
+		}
+		fn(sysinfo.LightState)
+	})
+}
+
 func (bulb *SmartBulb) IsOff() bool {
 	return !bulb.IsOn()
 }
@@ -37,6 +45,9 @@ func (bulb *SmartBulb) TurnOn() error {
 		return err
 	}
 	log.Println(res)
+	bulb.patchLightState(func(light *LightState) {
+		light.OnOff = 1
+	})
 	return nil
 }
 
@@ -48,20 +59,15 @@ func (bulb *SmartBulb) TurnOff() error {
 		return err
 	}
 	log.Println(res)
+	bulb.patchLightState(func(light *LightState) {
+		light.OnOff = 0
+	})
 	return nil
 }
 
-func (bulb *SmartBulb) IsDimmable() bool {
-	sysinfo := bulb.GetSysInfo()
-	if sysinfo == nil {
-		return false
-	}
-	return sysinfo.IsDimmable > 0
-}
-
 func (bulb *SmartBulb) SetBrightness(b int) error {
 	if !bulb.IsDimmable() {
-		return errors.New("device is not dimmable")
+		return ErrNotDimmable
 	}
 	if b <= 0 {
 		return bulb.TurnOff()
@@ -76,10 +82,10 @@ func (bulb *SmartBulb) SetBrightness(b int) error {
 		return err
 	}
 	log.Println(res)
-	return nil
-}
-
-func (bulb *SmartBulb) SetLED(state bool) error {
+	bulb.patchLightState(func(light *LightState) {
+		light.OnOff = 1
+		light.Brightness = b
+	})
 	return nil
 }
 
@@ -1,5 +1,89 @@
 package kasa
 
+import (
+	"log"
+)
+
 type SmartDimmer struct {
 	*BaseDevice
 }
+
+func (dimmer *SmartDimmer) IsOff() bool {
+	return !dimmer.IsOn()
+}
+
+func (dimmer *SmartDimmer) IsOn() bool {
+	sysinfo := dimmer.GetSysInfo()
+	if sysinfo == nil {
+		return false
+	}
+	return sysinfo.RelayState > 0
+}
+
+func (dimmer *SmartDimmer) IsDimmable() bool {
+	return true
+}
+
+// Brightness returns the brightness the dimmer last reported, from 0 to
+// 100.
+func (dimmer *SmartDimmer) Brightness() int {
+	sysinfo := dimmer.GetSysInfo()
+	if sysinfo == nil {
+		return 0
+	}
+	return sysinfo.Brightness
+}
+
+func (dimmer *SmartDimmer) TurnOn() error {
+	var res interface{}
+	err := dimmer.Query(&res, "system", "set_relay_state", map[string]interface{}{"state": 1})
+	if err != nil {
+		return err
+	}
+	if Debug {
+		log.Println("TurnOn() =>", res)
+	}
+	dimmer.patchSysInfo(func(sysinfo *SysInfo) {
+		sysinfo.RelayState = 1
+	})
+	return nil
+}
+
+func (dimmer *SmartDimmer) TurnOff() error {
+	var res interface{}
+	err := dimmer.Query(&res, "system", "set_relay_state", map[string]interface{}{"state": 0})
+	if err != nil {
+		return err
+	}
+	if Debug {
+		log.Println("TurnOff() =>", res)
+	}
+	dimmer.patchSysInfo(func(sysinfo *SysInfo) {
+		sysinfo.RelayState = 0
+	})
+	return nil
+}
+
+func (dimmer *SmartDimmer) SetBrightness(b int) error {
+	if b <= 0 {
+		return dimmer.TurnOff()
+	}
+	if b > 100 {
+		b = 100
+	}
+	var res interface{}
+	err := dimmer.Query(&res, "smartlife.iot.dimmer", "set_brightness", map[string]interface{}{"brightness": b})
+	if err != nil {
+		return err
+	}
+	if Debug {
+		log.Println("SetBrightness() =>", res)
+	}
+	dimmer.patchSysInfo(func(sysinfo *SysInfo) {
+		sysinfo.Brightness = b
+	})
+	if dimmer.IsOff() {
+		return dimmer.TurnOn()
+	}
+	return nil
+}
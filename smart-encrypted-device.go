@@ -0,0 +1,109 @@
+package kasa
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const DeviceTypeEncrypted = DeviceType("EncryptedDevice")
+
+type TransportType string
+
+const (
+	TransportXOR = TransportType("XOR")
+	TransportKLAP = TransportType("KLAP")
+	TransportAES = TransportType("AES")
+)
+
+var ErrUnsupportedTransport = errors.New("unsupported transport")
+
+type EncryptionScheme struct {
+	IsSupportHTTPS bool `json:"is_support_https"`
+	EncryptType string `json:"encrypt_type"`
+	HTTPPort int `json:"http_port"`
+	LoginVersion int `json:"lv,omitempty"`
+}
+
+// DiscoveryResult is what newer devices report in reply to a discovery
+// packet on DISCOVERY_PORT.
+type DiscoveryResult struct {
+	DeviceType string `json:"device_type"`
+	DeviceModel string `json:"device_model"`
+	IP string `json:"ip"`
+	MAC string `json:"mac"`
+	DeviceID string `json:"device_id"`
+	Owner string `json:"owner,omitempty"`
+	HardwareVersion string `json:"hw_ver,omitempty"`
+	IsSupportIOTCloud bool `json:"is_support_iot_cloud"`
+	ObdSrc string `json:"obd_src,omitempty"`
+	FactoryDefault bool `json:"factory_default"`
+	EncryptionScheme *EncryptionScheme `json:"mgt_encrypt_schm,omitempty"`
+}
+
+type DiscoveryResponse struct {
+	Result *DiscoveryResult `json:"result"`
+	ErrorCode int `json:"error_code"`
+}
+
+// RequiredTransport reports which transport the device expects to be
+// spoken to with.
+func (res *DiscoveryResult) RequiredTransport() TransportType {
+	if res.EncryptionScheme == nil || res.EncryptionScheme.EncryptType == "" {
+		if strings.HasPrefix(res.DeviceType, "SMART.") {
+			return TransportAES
+		}
+		return TransportXOR
+	}
+	return TransportType(strings.ToUpper(res.EncryptionScheme.EncryptType))
+}
+
+func (res *DiscoveryResult) sysInfo() *SysInfo {
+	return &SysInfo{
+		DeviceID: res.DeviceID,
+		HardwareVersion: res.HardwareVersion,
+		IsFactory: res.FactoryDefault,
+		MACAddr: strings.ReplaceAll(res.MAC, "-", ":"),
+		MicType: res.DeviceType,
+		Model: res.DeviceModel,
+		ObdSrc: res.ObdSrc,
+	}
+}
+
+type unsupportedTransport struct {
+	transport TransportType
+}
+
+func (t unsupportedTransport) Query(host string, req interface{}, dst interface{}) error {
+	return fmt.Errorf("%w: %s requires %s", ErrUnsupportedTransport, host, t.transport)
+}
+
+// EncryptedDevice is a device that only answered the newer discovery
+// protocol.  It can't be controlled over the legacy XOR protocol; queries
+// fail with ErrUnsupportedTransport.  Its sysinfo holds what little the
+// discovery reply revealed.
+type EncryptedDevice struct {
+	*BaseDevice
+	Discovery *DiscoveryResult `json:"discovery"`
+}
+
+func newEncryptedDevice(addr string, res *DiscoveryResult) *EncryptedDevice {
+	info := &Query{System: &SysInfoResponse{SysInfo: res.sysInfo()}}
+	info.setUpdateTime()
+	base := &BaseDevice{
+		Addr: addr,
+		Info: info,
+		transport: unsupportedTransport{res.RequiredTransport()},
+	}
+	dev := &EncryptedDevice{BaseDevice: base, Discovery: res}
+	base.self = dev
+	return dev
+}
+
+func (dev *EncryptedDevice) DeviceType() DeviceType {
+	return DeviceTypeEncrypted
+}
+
+func (dev *EncryptedDevice) RequiredTransport() TransportType {
+	return dev.Discovery.RequiredTransport()
+}
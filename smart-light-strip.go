@@ -4,3 +4,10 @@ type SmartLightStrip struct {
 	*BaseDevice
 }
 
+func (strip *SmartLightStrip) GetLightService() string {
+	return "smartlife.iot.lightStrip"
+}
+
+func (strip *SmartLightStrip) GetTimeService() string {
+	return "smartlife.iot.common.timesetting"
+}
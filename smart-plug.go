@@ -28,6 +28,9 @@ func (plug *SmartPlug) TurnOn() error {
 		return err
 	}
 	log.Println(res)
+	plug.patchSysInfo(func(sysinfo *SysInfo) {
+		sysinfo.RelayState = 1
+	})
 	return nil
 }
 
@@ -39,9 +42,8 @@ func (plug *SmartPlug) TurnOff() error {
 		return err
 	}
 	log.Println(res)
-	return nil
-}
-
-func (plug *SmartPlug) SetLED(state bool) error {
+	plug.patchSysInfo(func(sysinfo *SysInfo) {
+		sysinfo.RelayState = 0
+	})
 	return nil
 }
@@ -93,6 +93,7 @@ func (plug *SmartStripSocket) TurnOn() error {
 		return err
 	}
 	log.Println(res)
+	plug.setState(1)
 	return nil
 }
 
@@ -104,9 +105,38 @@ func (plug *SmartStripSocket) TurnOff() error {
 		return err
 	}
 	log.Println(res)
+	plug.setState(0)
 	return nil
 }
 
-func (plug *SmartStripSocket) SetLED(state bool) error {
-	return nil
+func (plug *SmartStripSocket) setState(state int) {
+	plug.patchSysInfo(func(sysinfo *SysInfo) {
+		for _, child := range sysinfo.Children {
+			if child.ID == plug.id {
+				child.State = state
+			}
+		}
+	})
+}
+
+func (plug *SmartStripSocket) GetCurrentConsumption() (float64, error) {
+	return currentConsumption(plug)
+}
+
+func (plug *SmartStripSocket) Module(name ModuleName) Module {
+	spec := lookupModuleSpec(name)
+	if spec == nil {
+		return nil
+	}
+	return newModule(spec, plug, plug.BaseDevice, plug.DeviceID())
+}
+
+func (plug *SmartStripSocket) Modules() []Module {
+	modules := []Module{}
+	for _, spec := range listModuleSpecs() {
+		if m := newModule(spec, plug, plug.BaseDevice, plug.DeviceID()); m != nil {
+			modules = append(modules, m)
+		}
+	}
+	return modules
 }
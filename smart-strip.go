@@ -58,7 +58,3 @@ func (strip *SmartStrip) TurnOff() error {
 	}
 	return nil
 }
-
-func (strip *SmartStrip) SetLED(state bool) error {
-	return nil
-}
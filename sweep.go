@@ -0,0 +1,162 @@
+package kasa
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	DEFAULT_SWEEP_CONCURRENCY = 64
+	DEFAULT_SWEEP_TIMEOUT = 2 * time.Second
+	MAX_SWEEP_PREFIX = 16
+)
+
+// SweepOptions controls a unicast sweep of one or more subnets, for
+// networks where discovery broadcasts don't reach the devices.
+type SweepOptions struct {
+	// CIDRs lists the IPv4 networks to probe, e.g. "10.20.0.0/22".
+	// Networks larger than a /16 are rejected.
+	CIDRs []string
+	// Concurrency is the number of hosts probed at once.  Zero means
+	// DEFAULT_SWEEP_CONCURRENCY.
+	Concurrency int
+	// Timeout bounds each probe.  Zero means DEFAULT_SWEEP_TIMEOUT.
+	Timeout time.Duration
+	// TCP probes over TCP instead of UDP.
+	TCP bool
+}
+
+func (opts *SweepOptions) transport() Transport {
+	timeout := opts.Timeout
+	if timeout <= 0 {
+		timeout = DEFAULT_SWEEP_TIMEOUT
+	}
+	if opts.TCP {
+		return tcpTransport{timeout: timeout}
+	}
+	return &UDPTransport{Timeout: timeout}
+}
+
+func (opts *SweepOptions) concurrency() int {
+	if opts.Concurrency <= 0 {
+		return DEFAULT_SWEEP_CONCURRENCY
+	}
+	return opts.Concurrency
+}
+
+func sweepHosts(cidrs []string) ([]net.IP, error) {
+	hosts := []net.IP{}
+	for _, cidr := range cidrs {
+		_, ipnet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, err
+		}
+		base := ipnet.IP.To4()
+		if base == nil {
+			return nil, fmt.Errorf("%s is not an IPv4 network", cidr)
+		}
+		ones, bits := ipnet.Mask.Size()
+		if ones < MAX_SWEEP_PREFIX {
+			return nil, fmt.Errorf("%s is too large to sweep; split it into /%d networks or smaller", cidr, MAX_SWEEP_PREFIX)
+		}
+		size := uint32(1) << uint(bits - ones)
+		first, last := uint32(0), size - 1
+		if size > 2 {
+			// skip the network and broadcast addresses
+			first, last = 1, size - 2
+		}
+		start := binary.BigEndian.Uint32(base)
+		for i := first; i <= last; i++ {
+			ip := make(net.IP, net.IPv4len)
+			binary.BigEndian.PutUint32(ip, start + i)
+			hosts = append(hosts, ip)
+		}
+	}
+	return hosts, nil
+}
+
+func probeHost(transport Transport, host string) (SmartDevice, error) {
+	req := map[string]interface{}{
+		"system": map[string]interface{}{
+			"get_sysinfo": nil,
+		},
+	}
+	info := &Query{}
+	err := transport.Query(host, req, info)
+	if err != nil {
+		return nil, err
+	}
+	if info.System == nil || info.System.SysInfo == nil {
+		return nil, fmt.Errorf("%s returned no sysinfo", host)
+	}
+	info.setUpdateTime()
+	dev := &BaseDevice{Addr: host, Info: info}
+	return dev.AsConcrete(), nil
+}
+
+// SweepStream probes every host in the given networks with a unicast
+// get_sysinfo request and sends each device that answers on the returned
+// channel.  The channel is closed once every host has been probed or ctx
+// is done.
+func SweepStream(ctx context.Context, opts *SweepOptions) (chan SmartDevice, error) {
+	if opts == nil {
+		opts = &SweepOptions{}
+	}
+	hosts, err := sweepHosts(opts.CIDRs)
+	if err != nil {
+		return nil, err
+	}
+	transport := opts.transport()
+	hostch := make(chan net.IP)
+	ch := make(chan SmartDevice, 10)
+	wg := &sync.WaitGroup{}
+	for i := 0; i < opts.concurrency(); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for ip := range hostch {
+				dev, err := probeHost(transport, ip.String())
+				if err != nil {
+					continue
+				}
+				select {
+				case ch <- dev:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+	go func() {
+		defer close(hostch)
+		for _, ip := range hosts {
+			select {
+			case hostch <- ip:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	go func() {
+		wg.Wait()
+		close(ch)
+	}()
+	return ch, nil
+}
+
+// Sweep probes the given networks and returns every device that answered.
+func Sweep(ctx context.Context, opts *SweepOptions) ([]SmartDevice, error) {
+	ch, err := SweepStream(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	devices := []SmartDevice{}
+	for dev := range ch {
+		devices = append(devices, dev)
+	}
+	return devices, ctx.Err()
+}
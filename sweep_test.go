@@ -0,0 +1,49 @@
+package kasa
+
+import (
+	"testing"
+)
+
+func TestSweepHosts(t *testing.T) {
+	tests := []struct {
+		cidrs []string
+		count int
+		first string
+		last string
+		fails bool
+	}{
+		{cidrs: []string{"192.168.1.7/32"}, count: 1, first: "192.168.1.7", last: "192.168.1.7"},
+		{cidrs: []string{"192.168.1.6/31"}, count: 2, first: "192.168.1.6", last: "192.168.1.7"},
+		{cidrs: []string{"192.168.1.4/30"}, count: 2, first: "192.168.1.5", last: "192.168.1.6"},
+		{cidrs: []string{"192.168.1.77/24"}, count: 254, first: "192.168.1.1", last: "192.168.1.254"},
+		{cidrs: []string{"10.0.0.0/16"}, count: 65534, first: "10.0.0.1", last: "10.0.255.254"},
+		{cidrs: []string{"10.0.0.0/32", "10.0.1.0/31"}, count: 3, first: "10.0.0.0", last: "10.0.1.1"},
+		{cidrs: []string{"10.0.0.0/15"}, fails: true},
+		{cidrs: []string{"192.168.1.0/24", "10.0.0.0/8"}, fails: true},
+		{cidrs: []string{"fd00::/120"}, fails: true},
+		{cidrs: []string{"192.168.1.1"}, fails: true},
+	}
+	for _, tc := range tests {
+		hosts, err := sweepHosts(tc.cidrs)
+		if tc.fails {
+			if err == nil {
+				t.Errorf("%v: expected an error, got %d hosts", tc.cidrs, len(hosts))
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%v: %s", tc.cidrs, err)
+			continue
+		}
+		if len(hosts) != tc.count {
+			t.Errorf("%v: %d hosts, want %d", tc.cidrs, len(hosts), tc.count)
+			continue
+		}
+		if first := hosts[0].String(); first != tc.first {
+			t.Errorf("%v: first host %s, want %s", tc.cidrs, first, tc.first)
+		}
+		if last := hosts[len(hosts) - 1].String(); last != tc.last {
+			t.Errorf("%v: last host %s, want %s", tc.cidrs, last, tc.last)
+		}
+	}
+}
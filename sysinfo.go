@@ -0,0 +1,119 @@
+package kasa
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// plainSysInfo has SysInfo's fields without its JSON methods, so they can
+// use the default encoding internally.
+type plainSysInfo SysInfo
+
+var sysInfoFields map[string]int
+var sysInfoFieldsOnce sync.Once
+
+// sysInfoFieldIndex maps each JSON key declared on SysInfo to its field.
+func sysInfoFieldIndex() map[string]int {
+	sysInfoFieldsOnce.Do(func() {
+		sysInfoFields = map[string]int{}
+		t := reflect.TypeOf(SysInfo{})
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			if !field.IsExported() {
+				continue
+			}
+			name := strings.Split(field.Tag.Get("json"), ",")[0]
+			if name != "" && name != "-" {
+				sysInfoFields[name] = i
+			}
+		}
+	})
+	return sysInfoFields
+}
+
+func (sysinfo *SysInfo) UnmarshalJSON(data []byte) error {
+	err := json.Unmarshal(data, (*plainSysInfo)(sysinfo))
+	if err != nil {
+		return err
+	}
+	raw := map[string]json.RawMessage{}
+	err = json.Unmarshal(data, &raw)
+	if err != nil {
+		return err
+	}
+	sysinfo.raw = raw
+	return nil
+}
+
+// MarshalJSON emits the declared fields plus every key the device
+// originally reported, so nothing is lost in a round trip.  Declared
+// fields always reflect the current typed values.
+func (sysinfo *SysInfo) MarshalJSON() ([]byte, error) {
+	data, err := json.Marshal((*plainSysInfo)(sysinfo))
+	if err != nil || len(sysinfo.raw) == 0 {
+		return data, err
+	}
+	out := map[string]json.RawMessage{}
+	err = json.Unmarshal(data, &out)
+	if err != nil {
+		return nil, err
+	}
+	fields := sysInfoFieldIndex()
+	v := reflect.ValueOf(sysinfo).Elem()
+	for key, val := range sysinfo.raw {
+		if _, ok := out[key]; ok {
+			continue
+		}
+		idx, known := fields[key]
+		if !known {
+			out[key] = val
+			continue
+		}
+		// a declared field left out by omitempty; the device did send it,
+		// so emit its current value
+		fieldData, err := json.Marshal(v.Field(idx).Interface())
+		if err != nil {
+			return nil, err
+		}
+		out[key] = fieldData
+	}
+	return json.Marshal(out)
+}
+
+// Raw returns the JSON value the device reported for key, whether or not
+// SysInfo declares a field for it, or nil if it wasn't reported.  Values
+// changed locally after the device reported them aren't reflected here.
+func (sysinfo *SysInfo) Raw(key string) json.RawMessage {
+	if sysinfo == nil {
+		return nil
+	}
+	return sysinfo.raw[key]
+}
+
+// DecodeRaw decodes the value the device reported for key into dst.  It
+// reports false if the key wasn't reported.
+func (sysinfo *SysInfo) DecodeRaw(key string, dst interface{}) (bool, error) {
+	val := sysinfo.Raw(key)
+	if val == nil {
+		return false, nil
+	}
+	return true, json.Unmarshal(val, dst)
+}
+
+// Extra returns the keys the device reported that SysInfo has no field
+// for, such as dev_state, ntc_state or hw_id on some models.
+func (sysinfo *SysInfo) Extra() map[string]json.RawMessage {
+	extra := map[string]json.RawMessage{}
+	if sysinfo == nil {
+		return extra
+	}
+	fields := sysInfoFieldIndex()
+	for key, val := range sysinfo.raw {
+		if _, known := fields[key]; !known {
+			extra[key] = val
+		}
+	}
+	return extra
+}
@@ -0,0 +1,65 @@
+package kasa
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestSysInfoRoundTripPatched(t *testing.T) {
+	tests := []struct {
+		name string
+		reported int
+		patched int
+	}{
+		{"turned on", 0, 1},
+		// relay_state is omitempty, so the reported value mustn't leak back
+		{"turned off", 1, 0},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			data, _ := json.Marshal(map[string]interface{}{
+				"alias": "lamp",
+				"relay_state": tc.reported,
+				"dev_state": "normal",
+				"ntc_state": 0,
+				"next_action": map[string]interface{}{"type": -1},
+			})
+			sysinfo := &SysInfo{}
+			err := json.Unmarshal(data, sysinfo)
+			if err != nil {
+				t.Fatal(err)
+			}
+			dev := &BaseDevice{Addr: "192.0.2.1", Info: &Query{System: &SysInfoResponse{SysInfo: sysinfo}}}
+			dev.patchSysInfo(func(sysinfo *SysInfo) {
+				sysinfo.RelayState = tc.patched
+			})
+			out, err := json.Marshal(dev.cachedSysInfo())
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := map[string]interface{}{}
+			err = json.Unmarshal(out, &got)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := map[string]interface{}{
+				"alias": "lamp",
+				"relay_state": float64(tc.patched),
+				"dev_state": "normal",
+				"ntc_state": float64(0),
+			}
+			for key, val := range want {
+				if got[key] != val {
+					t.Errorf("%s = %#v, want %#v", key, got[key], val)
+				}
+			}
+			action, ok := got["next_action"].(map[string]interface{})
+			if !ok || action["type"] != float64(-1) {
+				t.Errorf("next_action = %#v, want {type: -1}", got["next_action"])
+			}
+			if sysinfo.RelayState != tc.reported {
+				t.Errorf("patch modified the original sysinfo: relay_state = %d", sysinfo.RelayState)
+			}
+		})
+	}
+}
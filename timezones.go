@@ -2,6 +2,7 @@ package kasa
 
 import (
 	"log"
+	"strings"
 	"time"
 )
 
@@ -132,3 +133,22 @@ func (tz Timezone) Location() *time.Location {
 	}
 	return loc
 }
+
+// LookupTimezone finds the device timezone index for a location name such
+// as "America/New_York", or for one of the device's own descriptions such
+// as "UTC+01:00 - Belgrade, Bratislava".  Where several indexes share a
+// location, the lowest one is returned.
+func LookupTimezone(name string) (Timezone, bool) {
+	found := false
+	var match Timezone
+	for tz, tzName := range timezoneIndex {
+		if !strings.EqualFold(tzName, name) {
+			continue
+		}
+		if !found || tz < match {
+			match = tz
+			found = true
+		}
+	}
+	return match, found
+}
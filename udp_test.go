@@ -0,0 +1,108 @@
+package kasa
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// serveUDP answers each datagram on a local port with a get_sysinfo
+// response padded to size bytes, and reports the size of each request on
+// the returned channel.
+func serveUDP(t *testing.T, size int) chan int {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	setDevicePort(t, conn.LocalAddr())
+	t.Cleanup(func() { conn.Close() })
+	requests := make(chan int, 10)
+	go func() {
+		buf := make([]byte, MAX_UDP_PAYLOAD)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			requests <- n
+			if size <= 0 {
+				continue
+			}
+			res := `{"system":{"get_sysinfo":{"alias":"%s"}}}`
+			pad := size - len(res) + 2
+			conn.WriteTo(encrypt([]byte(fmt.Sprintf(res, strings.Repeat("x", pad)))), addr)
+		}
+	}()
+	return requests
+}
+
+func TestUDPTransport(t *testing.T) {
+	sysinfo := map[string]interface{}{"system": map[string]interface{}{"get_sysinfo": nil}}
+	tests := []struct {
+		name string
+		req interface{}
+		size int
+		maxSize int
+		sent bool
+		err func(err error) bool
+	}{
+		{name: "small response", req: sysinfo, size: 100, maxSize: 0, sent: true},
+		{name: "response at limit", req: sysinfo, size: 512, maxSize: 512, sent: true},
+		{
+			name: "response over limit",
+			req: sysinfo,
+			size: 513,
+			maxSize: 512,
+			sent: true,
+			err: func(err error) bool {
+				return err != nil && !IsNetError(err) && strings.Contains(err.Error(), "exceeds 512 bytes")
+			},
+		},
+		{
+			name: "request over datagram limit",
+			req: map[string]interface{}{"system": map[string]interface{}{"set_dev_alias": map[string]interface{}{"alias": strings.Repeat("x", MAX_UDP_PAYLOAD)}}},
+			size: 100,
+			sent: false,
+			err: func(err error) bool {
+				return err != nil && !IsNetError(err) && strings.Contains(err.Error(), "more than fits in a datagram")
+			},
+		},
+		{
+			name: "no response",
+			req: sysinfo,
+			size: 0,
+			sent: true,
+			err: IsNetError,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			requests := serveUDP(t, tc.size)
+			transport := &UDPTransport{Timeout: 100 * time.Millisecond, MaxResponseSize: tc.maxSize}
+			info := &Query{}
+			err := transport.Query("127.0.0.1", tc.req, info)
+			if tc.err == nil {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if info.System == nil || info.System.SysInfo == nil || len(info.System.SysInfo.Alias) == 0 {
+					t.Errorf("response not decoded: %+v", info)
+				}
+			} else if !tc.err(err) {
+				t.Errorf("unexpected error: %v", err)
+			}
+			select {
+			case <-requests:
+				if !tc.sent {
+					t.Error("oversized request was sent")
+				}
+			case <-time.After(50 * time.Millisecond):
+				if tc.sent {
+					t.Error("request was not sent")
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,100 @@
+package kasa
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestUsageSummary(t *testing.T) {
+	// minutes on per day, as the device would report them
+	minutes := map[string]int{
+		"2024-09-28": 60,
+		"2024-09-29": 30,
+		"2024-09-30": 20,
+		"2024-10-01": 10,
+		"2024-10-02": 99,
+		"2024-10-13": 5,
+		"2024-10-16": 7,
+		"2024-12-28": 40,
+		"2024-12-29": 15,
+		"2024-12-31": 25,
+		"2025-01-01": 3,
+		"2025-01-02": 4,
+	}
+	tests := []struct {
+		name string
+		now time.Time
+		summary UsageSummary
+		months []string
+	}{
+		{
+			name: "week started last month",
+			now: time.Date(2024, time.October, 1, 12, 0, 0, 0, time.UTC),
+			summary: UsageSummary{Today: 10 * time.Minute, ThisWeek: 60 * time.Minute, ThisMonth: 10 * time.Minute},
+			months: []string{"2024-10", "2024-09"},
+		},
+		{
+			name: "week started last year",
+			now: time.Date(2025, time.January, 2, 8, 0, 0, 0, time.UTC),
+			summary: UsageSummary{Today: 4 * time.Minute, ThisWeek: 47 * time.Minute, ThisMonth: 7 * time.Minute},
+			months: []string{"2025-01", "2024-12"},
+		},
+		{
+			name: "week within the month",
+			now: time.Date(2024, time.October, 16, 23, 0, 0, 0, time.UTC),
+			summary: UsageSummary{Today: 7 * time.Minute, ThisWeek: 12 * time.Minute, ThisMonth: 121 * time.Minute},
+			months: []string{"2024-10"},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			months := []string{}
+			dev := &BaseDevice{Addr: "192.0.2.1"}
+			dev.SetRetryPolicy(NoRetry)
+			dev.SetTransport(funcTransport(func(host string, req interface{}, dst interface{}) error {
+				data, _ := json.Marshal(req)
+				xreq := map[string]map[string]struct{
+					Year int `json:"year"`
+					Month time.Month `json:"month"`
+				}{}
+				err := json.Unmarshal(data, &xreq)
+				if err != nil {
+					return err
+				}
+				arg := xreq["schedule"]["get_daystat"]
+				months = append(months, fmt.Sprintf("%04d-%02d", arg.Year, arg.Month))
+				days := []*UsageDayStat{}
+				for day := 1; day <= 31; day++ {
+					date := time.Date(arg.Year, arg.Month, day, 0, 0, 0, 0, time.UTC)
+					if date.Month() != arg.Month {
+						break
+					}
+					if n, ok := minutes[date.Format("2006-01-02")]; ok {
+						days = append(days, &UsageDayStat{Year: arg.Year, Month: arg.Month, Day: day, Minutes: n})
+					}
+				}
+				res := map[string]interface{}{
+					"schedule": map[string]interface{}{
+						"get_daystat": map[string]interface{}{"day_list": days, "err_code": 0},
+					},
+				}
+				data, _ = json.Marshal(res)
+				return json.Unmarshal(data, dst)
+			}))
+			u := &Usage{&ModuleBase{name: UsageModule, target: "schedule", dev: dev, base: dev}}
+			summary, err := u.Summary(tc.now)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if *summary != tc.summary {
+				t.Errorf("summary = %+v, want %+v", *summary, tc.summary)
+			}
+			if !reflect.DeepEqual(months, tc.months) {
+				t.Errorf("queried months %v, want %v", months, tc.months)
+			}
+		})
+	}
+}
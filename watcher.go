@@ -0,0 +1,338 @@
+package kasa
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+const DEFAULT_WATCH_INTERVAL = 5 * time.Second
+
+// DEFAULT_RSSI_DROP is how many dB a device's signal must weaken by before
+// a watcher reports it.
+const DEFAULT_RSSI_DROP = 10
+
+type WatchEventType string
+
+const (
+	RelayChanged = WatchEventType("relay")
+	BrightnessChanged = WatchEventType("brightness")
+	LightStateChanged = WatchEventType("light_state")
+	AliasChanged = WatchEventType("alias")
+	RSSIDropped = WatchEventType("rssi_dropped")
+	DeviceOffline = WatchEventType("offline")
+	DeviceOnline = WatchEventType("online")
+)
+
+// WatchEvent reports a change a Watcher noticed.  Previous and Current are
+// the device's sysinfo before and after the change; for DeviceOffline,
+// Current is nil and Err is why the device didn't answer.
+type WatchEvent struct {
+	Type WatchEventType `json:"type"`
+	Device SmartDevice `json:"device"`
+	Previous *SysInfo `json:"previous,omitempty"`
+	Current *SysInfo `json:"current,omitempty"`
+	Err error `json:"-"`
+	Time time.Time `json:"time"`
+}
+
+type watchState struct {
+	sysinfo *SysInfo
+	offline bool
+	// rssi is the signal strength drops are measured from.  It follows
+	// the signal up, and down only when a drop is reported.
+	rssi int
+}
+
+type watchSubscriber struct {
+	types map[WatchEventType]bool
+	ch chan *WatchEvent
+	fn func(*WatchEvent)
+}
+
+func (sub *watchSubscriber) wants(evt *WatchEvent) bool {
+	return len(sub.types) == 0 || sub.types[evt.Type]
+}
+
+// Watcher polls devices and publishes events when their state changes.
+// Strip sockets are polled with a single query to their strip.
+type Watcher struct {
+	Interval time.Duration
+	RSSIDrop int
+	// Concurrency limits how many devices are polled at once,
+	// DEFAULT_GROUP_CONCURRENCY if zero.
+	Concurrency int
+	group *Group
+	// polling keeps polls from overlapping
+	polling sync.Mutex
+	// lock guards everything below, including the watchStates in states
+	lock sync.Mutex
+	states map[string]*watchState
+	subscribers map[int]*watchSubscriber
+	nextID int
+	dropped int
+}
+
+func NewWatcher(interval time.Duration, devices ...SmartDevice) *Watcher {
+	return &Watcher{
+		Interval: interval,
+		RSSIDrop: DEFAULT_RSSI_DROP,
+		group: NewGroup("watcher", devices...),
+		states: map[string]*watchState{},
+		subscribers: map[int]*watchSubscriber{},
+	}
+}
+
+func (w *Watcher) Add(devices ...SmartDevice) {
+	w.group.Add(devices...)
+}
+
+func (w *Watcher) Remove(id string) bool {
+	w.lock.Lock()
+	delete(w.states, id)
+	w.lock.Unlock()
+	return w.group.Remove(id)
+}
+
+func (w *Watcher) subscribe(sub *watchSubscriber, types []WatchEventType) func() {
+	sub.types = map[WatchEventType]bool{}
+	for _, t := range types {
+		sub.types[t] = true
+	}
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	id := w.nextID
+	w.nextID++
+	w.subscribers[id] = sub
+	return func() {
+		w.lock.Lock()
+		defer w.lock.Unlock()
+		if _, ok := w.subscribers[id]; ok {
+			delete(w.subscribers, id)
+			if sub.ch != nil {
+				close(sub.ch)
+			}
+		}
+	}
+}
+
+// Subscribe returns a channel receiving events of the given types, or of
+// every type if none are given, and a function that unsubscribes and
+// closes the channel.  Events are dropped rather than holding up the
+// watcher if the channel's buffer is full.
+func (w *Watcher) Subscribe(buffer int, types ...WatchEventType) (chan *WatchEvent, func()) {
+	ch := make(chan *WatchEvent, buffer)
+	return ch, w.subscribe(&watchSubscriber{ch: ch}, types)
+}
+
+// OnEvent calls fn with events of the given types, or of every type if
+// none are given, and returns a function that unsubscribes.  fn is called
+// from the polling goroutine, so it should return promptly.
+func (w *Watcher) OnEvent(fn func(*WatchEvent), types ...WatchEventType) func() {
+	return w.subscribe(&watchSubscriber{fn: fn}, types)
+}
+
+// Dropped returns the number of events dropped because a subscriber's
+// channel was full.
+func (w *Watcher) Dropped() int {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	return w.dropped
+}
+
+func (w *Watcher) publish(events []*WatchEvent) {
+	w.lock.Lock()
+	subs := make([]*watchSubscriber, 0, len(w.subscribers))
+	for _, sub := range w.subscribers {
+		subs = append(subs, sub)
+	}
+	for _, evt := range events {
+		for _, sub := range subs {
+			if sub.ch == nil || !sub.wants(evt) {
+				continue
+			}
+			select {
+			case sub.ch <- evt:
+			default:
+				w.dropped++
+			}
+		}
+	}
+	w.lock.Unlock()
+	for _, evt := range events {
+		for _, sub := range subs {
+			if sub.fn != nil && sub.wants(evt) {
+				sub.fn(evt)
+			}
+		}
+	}
+}
+
+func watchIsOn(dev SmartDevice, sysinfo *SysInfo) bool {
+	if isBulbFamily(dev) {
+		return sysinfo.LightState != nil && sysinfo.LightState.OnOff != 0
+	}
+	if dev.IsStripSocket() {
+		return sysinfo.State != 0
+	}
+	return sysinfo.RelayState != 0
+}
+
+// watchBrightness returns -1 when the brightness isn't known, such as for
+// bulbs that are off.
+func watchBrightness(dev SmartDevice, sysinfo *SysInfo) int {
+	if isBulbFamily(dev) {
+		if sysinfo.LightState == nil || sysinfo.LightState.OnOff == 0 {
+			return -1
+		}
+		return sysinfo.LightState.Brightness
+	}
+	if dev.IsDimmer() {
+		return sysinfo.Brightness
+	}
+	return -1
+}
+
+func lightStateChanged(prev, cur *LightState) bool {
+	if prev == nil || cur == nil {
+		return prev != cur
+	}
+	return prev.OnOff != cur.OnOff ||
+		prev.Hue != cur.Hue ||
+		prev.Saturation != cur.Saturation ||
+		prev.ColorTemp != cur.ColorTemp ||
+		prev.Brightness != cur.Brightness ||
+		prev.Mode != cur.Mode
+}
+
+// diff compares the device's new sysinfo with the previous one, or reports
+// the device offline if err isn't nil.  The device's state is updated
+// under w.lock, so Remove can run during a poll.
+func (w *Watcher) diff(dev SmartDevice, err error, now time.Time) []*WatchEvent {
+	id := groupKey(dev)
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	state, seen := w.states[id]
+	if !seen {
+		state = &watchState{}
+		w.states[id] = state
+	}
+	event := func(t WatchEventType, cur *SysInfo) *WatchEvent {
+		return &WatchEvent{Type: t, Device: dev, Previous: state.sysinfo, Current: cur, Time: now}
+	}
+	if err != nil {
+		if state.offline {
+			return nil
+		}
+		state.offline = true
+		evt := event(DeviceOffline, nil)
+		evt.Err = err
+		return []*WatchEvent{evt}
+	}
+	cur := dev.GetSysInfo()
+	if cur == nil {
+		return nil
+	}
+	events := []*WatchEvent{}
+	if state.offline {
+		state.offline = false
+		events = append(events, event(DeviceOnline, cur))
+	}
+	prev := state.sysinfo
+	if prev == nil {
+		state.sysinfo = cur
+		state.rssi = cur.RSSI
+		return events
+	}
+	if watchIsOn(dev, prev) != watchIsOn(dev, cur) {
+		events = append(events, event(RelayChanged, cur))
+	}
+	pb, cb := watchBrightness(dev, prev), watchBrightness(dev, cur)
+	if pb >= 0 && cb >= 0 && pb != cb {
+		events = append(events, event(BrightnessChanged, cur))
+	}
+	if isBulbFamily(dev) && lightStateChanged(prev.LightState, cur.LightState) {
+		events = append(events, event(LightStateChanged, cur))
+	}
+	if prev.Alias != cur.Alias {
+		events = append(events, event(AliasChanged, cur))
+	}
+	// sockets share their strip's radio and don't report a signal
+	if !dev.IsStripSocket() {
+		drop := w.RSSIDrop
+		if drop <= 0 {
+			drop = DEFAULT_RSSI_DROP
+		}
+		if cur.RSSI > state.rssi {
+			state.rssi = cur.RSSI
+		} else if cur.RSSI <= state.rssi - drop {
+			events = append(events, event(RSSIDropped, cur))
+			state.rssi = cur.RSSI
+		}
+	}
+	state.sysinfo = cur
+	return events
+}
+
+// updateKey identifies what updating dev queries: its underlying device,
+// or for types baseDevice doesn't know, the device itself.
+func updateKey(dev SmartDevice) interface{} {
+	if base := baseDevice(dev); base != nil {
+		return base
+	}
+	return groupKey(dev)
+}
+
+// Poll updates every device once, publishes any changes and returns them.
+// The first poll of a device records its state without reporting
+// anything, unless the device doesn't answer.
+func (w *Watcher) Poll(ctx context.Context) []*WatchEvent {
+	w.polling.Lock()
+	defer w.polling.Unlock()
+	devices := w.group.Devices()
+	// one update per underlying device, so a strip is queried once for
+	// all its sockets
+	updaters := map[interface{}]SmartDevice{}
+	keys := make([]interface{}, len(devices))
+	batch := NewGroup("watcher")
+	batch.Concurrency = w.Concurrency
+	for i, dev := range devices {
+		key := updateKey(dev)
+		keys[i] = key
+		if _, ok := updaters[key]; ok {
+			continue
+		}
+		updaters[key] = dev
+		batch.Add(dev)
+	}
+	results := batch.Update(ctx)
+	if ctx.Err() != nil {
+		return nil
+	}
+	now := time.Now()
+	events := []*WatchEvent{}
+	for i, dev := range devices {
+		err := results[groupKey(updaters[keys[i]])]
+		events = append(events, w.diff(dev, err, now)...)
+	}
+	w.publish(events)
+	return events
+}
+
+// Run polls every Interval until ctx is done.
+func (w *Watcher) Run(ctx context.Context) error {
+	interval := w.Interval
+	if interval <= 0 {
+		interval = DEFAULT_WATCH_INTERVAL
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		w.Poll(ctx)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
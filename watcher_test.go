@@ -0,0 +1,53 @@
+package kasa
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// wrappedDevice is a SmartDevice type baseDevice doesn't know about.
+type wrappedDevice struct {
+	SmartDevice
+}
+
+func TestWatcherPollUnknownTypes(t *testing.T) {
+	calls := map[string]int{}
+	relays := map[string]int{}
+	w := NewWatcher(time.Minute)
+	for i := 0; i < 3; i++ {
+		id := fmt.Sprintf("PLUG%d", i)
+		dev := &BaseDevice{Addr: fmt.Sprintf("192.0.2.%d", i + 1)}
+		dev.SetRetryPolicy(NoRetry)
+		dev.SetTransport(funcTransport(func(host string, req interface{}, dst interface{}) error {
+			calls[id]++
+			res := map[string]interface{}{
+				"system": map[string]interface{}{
+					"get_sysinfo": map[string]interface{}{"deviceId": id, "model": "HS100(US)", "relay_state": relays[id]},
+				},
+			}
+			data, _ := json.Marshal(res)
+			return json.Unmarshal(data, dst)
+		}))
+		err := dev.Update()
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Add(wrappedDevice{dev})
+	}
+	w.Concurrency = 1
+	w.Poll(context.Background())
+	relays["PLUG2"] = 1
+	events := w.Poll(context.Background())
+	for i := 0; i < 3; i++ {
+		id := fmt.Sprintf("PLUG%d", i)
+		if calls[id] != 3 {
+			t.Errorf("%s queried %d times, want once up front and once per poll", id, calls[id])
+		}
+	}
+	if len(events) != 1 || events[0].Type != RelayChanged || events[0].Device.DeviceID() != "PLUG2" {
+		t.Errorf("events = %+v, want one relay change on PLUG2", events)
+	}
+}